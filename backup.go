@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackupEngine keeps numbered backups of a database in one directory.
+// Tables and blob files never change once written, so they are stored once
+// under shared/, named after their file number, checksum and size, and
+// every backup that contains them refers to the same copy. The manifest,
+// CURRENT and the WALs belong to a single backup and live in private/<id>.
+// meta/<id> lists the files of a backup with their checksums.
+type BackupEngine struct {
+	mu  sync.Mutex
+	dir string
+}
+
+type BackupInfo struct {
+	ID        int
+	Timestamp time.Time
+	Size      int64
+	NumFiles  int
+}
+
+type backupFile struct {
+	// Name is the file name in the database, Path where the backup keeps it
+	// relative to the backup directory.
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum uint32 `json:"checksum"`
+}
+
+type backupMeta struct {
+	ID        int          `json:"id"`
+	Timestamp time.Time    `json:"timestamp"`
+	Files     []backupFile `json:"files"`
+}
+
+func (meta *backupMeta) info() BackupInfo {
+	info := BackupInfo{ID: meta.ID, Timestamp: meta.Timestamp, NumFiles: len(meta.Files)}
+	for _, file := range meta.Files {
+		info.Size += file.Size
+	}
+	return info
+}
+
+const (
+	backupSharedDir  = "shared"
+	backupPrivateDir = "private"
+	backupMetaDir    = "meta"
+)
+
+func OpenBackupEngine(dir string) (*BackupEngine, error) {
+	for _, sub := range []string{backupSharedDir, backupPrivateDir, backupMetaDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create backup directory: %w", err)
+		}
+	}
+	return &BackupEngine{dir: dir}, nil
+}
+
+// CreateBackup takes a checkpoint of db and copies it into a new backup, so
+// the backup shares no file with the database. Shared files an earlier
+// backup already holds are not stored again.
+func (b *BackupEngine) CreateBackup(db *Engine) (BackupInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	metas, err := b.readMetas()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	id := 1
+	if len(metas) > 0 {
+		id = metas[len(metas)-1].ID + 1
+	}
+
+	// leftovers of a backup that failed with the same id
+	checkpointDir := filepath.Join(b.dir, fmt.Sprintf("checkpoint-%d.tmp", id))
+	privateDir := filepath.Join(b.dir, backupPrivateDir, strconv.Itoa(id))
+	os.RemoveAll(checkpointDir)
+	os.RemoveAll(privateDir)
+
+	if err := db.Checkpoint(checkpointDir); err != nil {
+		return BackupInfo{}, err
+	}
+	defer os.RemoveAll(checkpointDir)
+
+	if err := os.MkdirAll(privateDir, 0755); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(checkpointDir)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	meta := &backupMeta{ID: id, Timestamp: time.Now()}
+	for _, entry := range entries {
+		name := entry.Name()
+		file, err := b.backUpFile(id, filepath.Join(checkpointDir, name))
+		if err != nil {
+			return BackupInfo{}, fmt.Errorf("failed to back up %s: %w", name, err)
+		}
+		meta.Files = append(meta.Files, file)
+	}
+
+	if err := b.writeMeta(meta); err != nil {
+		return BackupInfo{}, err
+	}
+	return meta.info(), nil
+}
+
+// backUpFile copies the checkpoint file src into backup id. Shared files are
+// named after their checksum, so they are hashed first and only copied when
+// no earlier backup holds them.
+func (b *BackupEngine) backUpFile(id int, src string) (backupFile, error) {
+	name := filepath.Base(src)
+	if !isSharedFile(name) {
+		file := backupFile{Name: name, Path: filepath.Join(backupPrivateDir, strconv.Itoa(id), name)}
+		var err error
+		file.Size, file.Checksum, err = copyFile(src, filepath.Join(b.dir, file.Path))
+		return file, err
+	}
+
+	size, checksum, err := fileChecksum(src)
+	if err != nil {
+		return backupFile{}, err
+	}
+	ext := filepath.Ext(name)
+	file := backupFile{
+		Name:     name,
+		Path:     filepath.Join(backupSharedDir, fmt.Sprintf("%s_%d_%d%s", strings.TrimSuffix(name, ext), checksum, size, ext)),
+		Size:     size,
+		Checksum: checksum,
+	}
+
+	dst := filepath.Join(b.dir, file.Path)
+	if _, err := os.Stat(dst); err == nil {
+		return file, nil
+	}
+	copied, copiedChecksum, err := copyFile(src, dst+".tmp")
+	if err != nil {
+		return backupFile{}, err
+	}
+	if copied != size || copiedChecksum != checksum {
+		os.Remove(dst + ".tmp")
+		return backupFile{}, fmt.Errorf("%s changed while it was copied", name)
+	}
+	return file, os.Rename(dst+".tmp", dst)
+}
+
+// GetBackupInfo lists the backups from oldest to newest.
+func (b *BackupEngine) GetBackupInfo() ([]BackupInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	metas, err := b.readMetas()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]BackupInfo, len(metas))
+	for i, meta := range metas {
+		infos[i] = meta.info()
+	}
+	return infos, nil
+}
+
+// VerifyBackup checks the size and checksum of every file of backup id.
+func (b *BackupEngine) VerifyBackup(id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	meta, err := b.readMeta(id)
+	if err != nil {
+		return err
+	}
+	for _, file := range meta.Files {
+		size, checksum, err := fileChecksum(filepath.Join(b.dir, file.Path))
+		if err != nil {
+			return fmt.Errorf("backup %d: failed to read %s: %w", id, file.Name, err)
+		}
+		if size != file.Size || checksum != file.Checksum {
+			return fmt.Errorf("backup %d: %s is corrupted", id, file.Name)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup copies backup id to targetDir, which must not exist yet or
+// be empty. Every file is checked against its checksum on the way.
+func (b *BackupEngine) RestoreBackup(id int, targetDir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	meta, err := b.readMeta(id)
+	if err != nil {
+		return err
+	}
+
+	if entries, err := os.ReadDir(targetDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("restore target %s is not empty", targetDir)
+	}
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+
+	for _, file := range meta.Files {
+		if err := restoreFile(filepath.Join(b.dir, file.Path), filepath.Join(targetDir, file.Name), file); err != nil {
+			os.RemoveAll(targetDir)
+			return fmt.Errorf("failed to restore backup %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// RestoreLatestBackup restores the newest backup to targetDir.
+func (b *BackupEngine) RestoreLatestBackup(targetDir string) error {
+	infos, err := b.GetBackupInfo()
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("no backups in %s", b.dir)
+	}
+	return b.RestoreBackup(infos[len(infos)-1].ID, targetDir)
+}
+
+// DeleteBackup removes backup id and the shared files no other backup
+// refers to.
+func (b *BackupEngine) DeleteBackup(id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := b.readMeta(id); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(b.dir, backupMetaDir, strconv.Itoa(id))); err != nil {
+		return fmt.Errorf("failed to delete backup %d: %w", id, err)
+	}
+	if err := os.RemoveAll(filepath.Join(b.dir, backupPrivateDir, strconv.Itoa(id))); err != nil {
+		return fmt.Errorf("failed to delete backup %d: %w", id, err)
+	}
+	return b.deleteUnreferencedFiles()
+}
+
+// PurgeOldBackups deletes all but the newest keep backups.
+func (b *BackupEngine) PurgeOldBackups(keep int) error {
+	infos, err := b.GetBackupInfo()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(infos)-keep; i++ {
+		if err := b.DeleteBackup(infos[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BackupEngine) deleteUnreferencedFiles() error {
+	metas, err := b.readMetas()
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool)
+	for _, meta := range metas {
+		for _, file := range meta.Files {
+			referenced[file.Path] = true
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(b.dir, backupSharedDir))
+	if err != nil {
+		return fmt.Errorf("failed to read backup directory: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(backupSharedDir, entry.Name())
+		if referenced[path] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(b.dir, path)); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (b *BackupEngine) readMeta(id int) (*backupMeta, error) {
+	data, err := os.ReadFile(filepath.Join(b.dir, backupMetaDir, strconv.Itoa(id)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %d: %w", id, err)
+	}
+	meta := &backupMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("failed to decode backup %d: %w", id, err)
+	}
+	return meta, nil
+}
+
+// readMetas returns the metadata of every backup ordered by id.
+func (b *BackupEngine) readMetas() ([]*backupMeta, error) {
+	entries, err := os.ReadDir(filepath.Join(b.dir, backupMetaDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var metas []*backupMeta
+	for _, entry := range entries {
+		id, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		meta, err := b.readMeta(id)
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].ID < metas[j].ID })
+	return metas, nil
+}
+
+// writeMeta installs the metadata of a backup last, so a backup that failed
+// half way is never listed.
+func (b *BackupEngine) writeMeta(meta *backupMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(b.dir, backupMetaDir, strconv.Itoa(meta.ID))
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write backup metadata: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write backup metadata: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync backup metadata: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// isSharedFile reports whether name is immutable and can be shared between
+// backups.
+func isSharedFile(name string) bool {
+	return strings.HasSuffix(name, ".sst") || strings.HasSuffix(name, ".blob")
+}
+
+func fileChecksum(path string) (int64, uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	hash := crc32.NewIEEE()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, hash.Sum32(), nil
+}
+
+// copyFile copies src to dst, syncs it and returns the size and checksum of
+// what was copied.
+func copyFile(src, dst string) (int64, uint32, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, 0, err
+	}
+	hash := crc32.NewIEEE()
+	size, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err != nil {
+		out.Close()
+		return 0, 0, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return 0, 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, 0, err
+	}
+	return size, hash.Sum32(), nil
+}
+
+// restoreFile copies src to dst and fails if the copy does not match the
+// size and checksum recorded for file.
+func restoreFile(src, dst string, file backupFile) error {
+	size, checksum, err := copyFile(src, dst)
+	if err != nil {
+		return err
+	}
+	if size != file.Size || checksum != file.Checksum {
+		return fmt.Errorf("%s is corrupted", file.Name)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestBackupEngine(t *testing.T) *BackupEngine {
+	t.Helper()
+	backups, err := OpenBackupEngine(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backups
+}
+
+func createBackup(t *testing.T, backups *BackupEngine, db *Engine) BackupInfo {
+	t.Helper()
+	info, err := backups.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// setAndFlush writes key and moves it into a table.
+func setAndFlush(t *testing.T, db *Engine, key, value string) {
+	t.Helper()
+	if err := db.Set(key, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompactRange("", ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreBackupReturnsBackedUpState(t *testing.T) {
+	db := openTestEngine(t, t.TempDir(), nil)
+	setAndFlush(t, db, "flushed", "1")
+	if err := db.Set("logged", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := openTestBackupEngine(t)
+	createBackup(t, backups, db)
+	if err := db.Set("later", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(t.TempDir(), "restored")
+	if err := backups.RestoreLatestBackup(target); err != nil {
+		t.Fatal(err)
+	}
+	restored := openTestEngine(t, target, nil)
+	assertValue(t, restored, "flushed", "1")
+	assertValue(t, restored, "logged", "2")
+	assertMissing(t, restored, "later")
+
+	if err := backups.RestoreLatestBackup(target); err == nil {
+		t.Fatal("restored into a directory that is not empty")
+	}
+}
+
+func TestBackupSharesNoFileWithDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestEngine(t, dir, nil)
+	setAndFlush(t, db, "a", "1")
+
+	backups := openTestBackupEngine(t)
+	info := createBackup(t, backups, db)
+
+	tables, _ := filepath.Glob(filepath.Join(dir, "*.sst"))
+	shared, _ := filepath.Glob(filepath.Join(backups.dir, backupSharedDir, "*"))
+	if len(tables) == 0 || len(shared) != len(tables) {
+		t.Fatalf("database has %d tables, backup %d shared files", len(tables), len(shared))
+	}
+	for _, table := range tables {
+		live, err := os.Stat(table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range shared {
+			backedUp, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if os.SameFile(live, backedUp) {
+				t.Fatalf("backup file %s is the live table %s", path, table)
+			}
+		}
+		// damage to the live table must not reach the backup
+		if err := os.Truncate(table, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := backups.VerifyBackup(info.ID); err != nil {
+		t.Fatalf("backup damaged along with the database: %v", err)
+	}
+}
+
+func TestVerifyBackupDetectsCorruption(t *testing.T) {
+	db := openTestEngine(t, t.TempDir(), nil)
+	setAndFlush(t, db, "a", "1")
+
+	backups := openTestBackupEngine(t)
+	info := createBackup(t, backups, db)
+	if err := backups.VerifyBackup(info.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	shared, _ := filepath.Glob(filepath.Join(backups.dir, backupSharedDir, "*"))
+	if err := os.WriteFile(shared[0], []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := backups.VerifyBackup(info.ID); err == nil {
+		t.Fatal("VerifyBackup accepted a corrupted file")
+	}
+
+	target := filepath.Join(t.TempDir(), "restored")
+	if err := backups.RestoreBackup(info.ID, target); err == nil {
+		t.Fatal("RestoreBackup accepted a corrupted file")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatal("failed restore left its target behind")
+	}
+}
+
+func TestPurgeOldBackupsKeepsNewest(t *testing.T) {
+	db := openTestEngine(t, t.TempDir(), nil)
+	backups := openTestBackupEngine(t)
+	for _, value := range []string{"1", "2", "3"} {
+		setAndFlush(t, db, "k"+value, value)
+		createBackup(t, backups, db)
+	}
+
+	if err := backups.PurgeOldBackups(1); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := backups.GetBackupInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].ID != 3 {
+		t.Fatalf("kept backups %+v, want only backup 3", infos)
+	}
+
+	meta, err := backups.readMeta(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referenced := 0
+	for _, file := range meta.Files {
+		if isSharedFile(file.Name) {
+			referenced++
+		}
+	}
+	shared, _ := filepath.Glob(filepath.Join(backups.dir, backupSharedDir, "*"))
+	if len(shared) != referenced {
+		t.Fatalf("%d shared files left, backup 3 refers to %d", len(shared), referenced)
+	}
+	if err := backups.VerifyBackup(3); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(t.TempDir(), "restored")
+	if err := backups.RestoreBackup(3, target); err != nil {
+		t.Fatal(err)
+	}
+	restored := openTestEngine(t, target, nil)
+	for _, value := range []string{"1", "2", "3"} {
+		assertValue(t, restored, "k"+value, value)
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/AmrMurad1/Go-Store/memtable"
 	"github.com/AmrMurad1/Go-Store/shared"
@@ -16,46 +20,188 @@ type Engine struct {
 	dir             string
 	lock            *sync.Mutex
 	maxMemtableSize int
+	sequence        int
+
+	// readOnly engines have no WAL of their own and never flush or compact.
+	readOnly bool
+	// closed is also read by iterators, which don't take lock.
+	closed atomic.Bool
+	// flushOnClose writes the memtable to level 0 in Close.
+	flushOnClose bool
+	// stop ends the catch-up goroutine of a secondary.
+	stop       chan struct{}
+	stopOnce   sync.Once
+	background sync.WaitGroup
+}
+
+// ErrReadOnly is returned by writes to an engine opened with OpenReadOnly or
+// OpenAsSecondary.
+var ErrReadOnly = errors.New("database is opened read-only")
+
+// ErrClosed is returned by every call made after Close, including those on
+// iterators created before it.
+var ErrClosed = sstable.ErrClosed
+
+// ErrLocked is returned when another engine already has the directory open
+// for writing.
+var ErrLocked = sstable.ErrLocked
+
+type Options struct {
+	sstable.Options
+	MaxMemtableSize int
+	// FlushOnClose writes the memtable to a table in Close, so the next open
+	// has no WAL to replay.
+	FlushOnClose bool
+	// SecondaryCatchUpInterval is how often an engine opened with
+	// OpenAsSecondary picks up the writes of the primary. Zero leaves it to
+	// TryCatchUpWithPrimary.
+	SecondaryCatchUpInterval time.Duration
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Options:                  *sstable.DefaultOptions(),
+		MaxMemtableSize:          1024 * 1024, // 1MB default
+		SecondaryCatchUpInterval: time.Second,
+	}
 }
 
 func NewEngine(dir string) (*Engine, error) {
+	return NewEngineWithOptions(dir, DefaultOptions())
+}
+
+func NewEngineWithOptions(dir string, opts *Options) (*Engine, error) {
 	db := &Engine{
 		dir:             dir,
 		lock:            &sync.Mutex{},
-		maxMemtableSize: 1024 * 1024, // 1MB default
+		maxMemtableSize: opts.MaxMemtableSize,
+		flushOnClose:    opts.FlushOnClose,
 	}
 
 	log.Printf("setup data path: %s...\n", db.dir)
 
 	var err error
-	db.memtable, err = memtable.NewMemtable(dir)
+	db.sstableManager, err = sstable.NewSSManager(dir, &opts.Options)
 	if err != nil {
 		log.Printf("setup failed: %v", err)
 		return nil, err
 	}
 
-	db.sstableManager, err = sstable.NewSSManager(dir)
+	logNumber := db.sstableManager.NewFileNumber()
+	db.memtable, err = memtable.NewMemtable(dir, logNumber, db.sstableManager.LogNumber())
 	if err != nil {
 		log.Printf("setup failed: %v", err)
+		db.sstableManager.Close()
 		return nil, err
 	}
 
+	db.sequence = max(db.sstableManager.MaxSequence(), db.memtable.MaxVersion())
+
 	log.Println("setup done")
 	return db, nil
 }
 
+// OpenReadOnly opens dir for reads only. The WALs are replayed into memory
+// but nothing in dir is written, flushed or compacted, and the directory
+// lock is not taken. No other engine should be writing to dir at the same
+// time; see OpenAsSecondary for that.
+func OpenReadOnly(dir string) (*Engine, error) {
+	return OpenReadOnlyWithOptions(dir, DefaultOptions())
+}
+
+func OpenReadOnlyWithOptions(dir string, opts *Options) (*Engine, error) {
+	db := &Engine{
+		dir:             dir,
+		lock:            &sync.Mutex{},
+		maxMemtableSize: opts.MaxMemtableSize,
+		readOnly:        true,
+	}
+
+	var err error
+	db.sstableManager, err = sstable.NewReadOnlySSManager(dir, &opts.Options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s read-only: %w", dir, err)
+	}
+
+	db.memtable, err = memtable.OpenReadOnly(dir, db.sstableManager.LogNumber())
+	if err != nil {
+		db.sstableManager.Close()
+		return nil, fmt.Errorf("failed to open %s read-only: %w", dir, err)
+	}
+
+	db.sequence = max(db.sstableManager.MaxSequence(), db.memtable.MaxVersion())
+	return db, nil
+}
+
+// Close stops background work, flushes the memtable when FlushOnClose is
+// set, syncs and closes the WAL and the manifest and releases the directory
+// lock. Every later call on db returns ErrClosed.
 func (db *Engine) Close() error {
-	return db.sstableManager.Close()
+	// the catch-up goroutine of a secondary takes db.lock
+	if db.stop != nil {
+		db.stopOnce.Do(func() { close(db.stop) })
+		db.background.Wait()
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	db.closed.Store(true)
+
+	var firstError error
+
+	if db.flushOnClose && !db.readOnly && db.memtable.Size() > 0 {
+		if err := db.flushMemtable(); err != nil {
+			firstError = fmt.Errorf("failed to flush memtable on close: %w", err)
+		}
+	}
+
+	if err := db.memtable.Close(); err != nil && firstError == nil {
+		firstError = err
+	}
+
+	if err := db.sstableManager.Close(); err != nil && firstError == nil {
+		firstError = err
+	}
+
+	return firstError
+}
+
+// checkWritable returns why db cannot be written to, if it can't. The caller
+// holds db.lock.
+func (db *Engine) checkWritable() error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	return nil
 }
 
 func (db *Engine) Get(key string) (string, error) {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	sharedKey := shared.Key(key)
+	if db.closed.Load() {
+		return "", ErrClosed
+	}
+
+	value, err := db.get(shared.Key(key))
+	if db.retryAfterCatchUp(err) {
+		value, err = db.get(shared.Key(key))
+	}
+	return value, err
+}
+
+func (db *Engine) get(sharedKey shared.Key) (string, error) {
+	deletedBelow := db.memtable.DeletedBelow(sharedKey)
 	entry, found := db.memtable.Get(sharedKey)
 	if found {
-		if !entry.Tombstone {
+		if !entry.Tombstone && entry.Version >= deletedBelow {
 			return string(entry.Value), nil
 		} else {
 			return "", fmt.Errorf("key does not exist")
@@ -67,7 +213,7 @@ func (db *Engine) Get(key string) (string, error) {
 		return "", err
 	}
 
-	if ssEntry != nil && !ssEntry.Tombstone {
+	if ssEntry != nil && !ssEntry.Tombstone && ssEntry.Version >= deletedBelow {
 		return string(ssEntry.Value), nil
 	}
 
@@ -78,81 +224,229 @@ func (db *Engine) Set(key string, val string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
 	sharedKey := shared.Key(key)
-	err := db.memtable.Set(sharedKey, []byte(val))
+	db.sequence++
+	err := db.memtable.Set(sharedKey, []byte(val), db.sequence)
 	if err != nil {
 		return err
 	}
 
-	if db.memtable.Size() >= db.maxMemtableSize {
-		log.Println("full table")
-		log.Println("loading to disk...")
-		err := db.flushToDisk()
-		if err != nil {
-			return err
-		}
-		db.memtable, err = memtable.NewMemtable(db.dir)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return db.flushIfFull()
 }
 
 func (db *Engine) Delete(key string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
 	sharedKey := shared.Key(key)
-	err := db.memtable.Delete(sharedKey)
+	db.sequence++
+	err := db.memtable.Delete(sharedKey, db.sequence)
 	if err != nil {
 		return err
 	}
 
-	if db.memtable.Size() >= db.maxMemtableSize {
-		log.Println("full table")
-		log.Println("loading to disk...")
-		err = db.flushToDisk()
-		if err != nil {
-			return err
-		}
-		db.memtable, err = memtable.NewMemtable(db.dir)
-		if err != nil {
-			return err
+	return db.flushIfFull()
+}
+
+// DeleteRange removes every key in [start, end) with a single range
+// tombstone instead of one tombstone per key.
+func (db *Engine) DeleteRange(start, end string) error {
+	if start >= end {
+		return fmt.Errorf("invalid range: %q is not before %q", start, end)
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	db.sequence++
+	err := db.memtable.DeleteRange(shared.Key(start), shared.Key(end), db.sequence)
+	if err != nil {
+		return err
+	}
+
+	return db.flushIfFull()
+}
+
+// CompactRange flushes the memtable and compacts every table holding keys in
+// [start, end) down to the bottom level, dropping what was deleted or
+// overwritten. Empty bounds leave that side of the range open, so
+// CompactRange("", "") compacts everything.
+func (db *Engine) CompactRange(start, end string) error {
+	db.lock.Lock()
+	err := db.checkWritable()
+	if err == nil && db.memtable.Size() > 0 {
+		err = db.flushMemtable()
+	}
+	db.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// the manager serializes compactions itself; reads and writes go on
+	// while this one merges
+
+	var startKey, endKey shared.Key
+	if start != "" {
+		startKey = shared.Key(start)
+	}
+	if end != "" {
+		endKey = shared.Key(end)
+	}
+	return db.sstableManager.CompactRange(startKey, endKey)
+}
+
+// IngestExternalFiles adds tables written by sstable.SstFileWriter without
+// going through the memtable or the WAL. All of them get one new sequence
+// number, so they shadow everything written before. The files are copied
+// into the database and may be changed or removed afterwards.
+func (db *Engine) IngestExternalFiles(paths []string) error {
+	return db.IngestExternalFilesWithOptions(paths, &sstable.IngestOptions{})
+}
+
+// IngestExternalFilesWithOptions is IngestExternalFiles with the files moved
+// rather than copied when opts.MoveFiles is set.
+func (db *Engine) IngestExternalFilesWithOptions(paths []string, opts *sstable.IngestOptions) error {
+	db.lock.Lock()
+	err := db.checkWritable()
+	db.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	files, err := sstable.OpenExternalFiles(paths)
+	if err != nil {
+		return err
+	}
+	// copying the files must not hold up reads and writes
+	ingestion, err := db.sstableManager.PrepareIngestion(files, opts)
+	if err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := db.checkWritable(); err != nil {
+		ingestion.Abort()
+		return err
+	}
+
+	// reads search the memtable first, so it must not hold older versions
+	// of ingested keys
+	for _, file := range files {
+		if db.memtable.Overlaps(file.MinKey, file.MaxKey) {
+			if err := db.flushMemtable(); err != nil {
+				ingestion.Abort()
+				return err
+			}
+			break
 		}
 	}
 
+	db.sequence++
+	return ingestion.Apply(uint64(db.sequence))
+}
+
+// Checkpoint creates dir holding a consistent copy of the database that
+// NewEngine can open. Writes wait until it is done; tables are hard linked,
+// so a checkpoint on the same file system takes little extra space.
+func (db *Engine) Checkpoint(dir string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := db.sstableManager.Checkpoint(dir); err != nil {
+		return fmt.Errorf("checkpoint failed: %w", err)
+	}
 	return nil
 }
 
-func (db *Engine) flushToDisk() error {
-	entries := db.memtable.All()
-	if len(entries) == 0 {
+// flushIfFull moves the memtable to level 0 once it reaches maxMemtableSize.
+func (db *Engine) flushIfFull() error {
+	if db.memtable.Size() < db.maxMemtableSize {
 		return nil
 	}
 
-	config := &sstable.SSTableConfig{
-		DataBlockSize:           4096,
-		FilterFalsePositiveRate: 0.01,
-		ExpectedEntryCount:      1000,
-	}
+	log.Println("full table")
+	return db.flushMemtable()
+}
 
-	filename := fmt.Sprintf("%s/temp.sst", db.dir)
-	writer, err := sstable.NewBlockWriter(filename, config)
+// flushMemtable writes the memtable to level 0 and starts a new one.
+func (db *Engine) flushMemtable() error {
+	log.Println("loading to disk...")
+	logNumber := db.sstableManager.NewFileNumber()
+	err := db.flushToDisk(logNumber)
 	if err != nil {
 		return err
 	}
+	// the flushed WAL is removed rather than synced
+	if err := db.memtable.Discard(); err != nil {
+		log.Printf("Warning: failed to remove flushed WAL: %v", err)
+	}
+	db.memtable, err = memtable.NewMemtable(db.dir, logNumber, logNumber)
+	return err
+}
 
-	for _, entry := range entries {
-		writer.Add(entry)
+// GetPropertiesOfAllTables returns the properties block of every live
+// SSTable keyed by file path. It is empty once db is closed.
+func (db *Engine) GetPropertiesOfAllTables() map[string]shared.MetaBlock {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed.Load() {
+		return map[string]shared.MetaBlock{}
+	}
+	return db.sstableManager.GetPropertiesOfAllTables()
+}
+
+// flushToDisk writes the memtable to a level 0 table. logNumber is the WAL
+// of the memtable that will replace it.
+func (db *Engine) flushToDisk(logNumber uint64) error {
+	entries := db.memtable.All()
+	rangeTombstones := db.memtable.RangeTombstones()
+	if len(entries) == 0 && len(rangeTombstones) == 0 {
+		return nil
 	}
 
-	writer.Finish()
+	config := db.sstableManager.LevelConfig(0)
+	config.IOPriority = sstable.IOPriorityHigh
 
-	newSSTable, err := sstable.Open(filename)
+	filename := filepath.Join(db.dir, db.sstableManager.NewTableFileName())
+	writer, err := sstable.NewBlockWriter(filename, config)
 	if err != nil {
 		return err
 	}
 
-	return db.sstableManager.AddSSTable(newSSTable)
+	// the memtable and its WAL stay until the table is installed
+	for _, entry := range entries {
+		if err := writer.Add(entry); err != nil {
+			writer.Abandon()
+			return fmt.Errorf("failed to flush memtable: %w", err)
+		}
+	}
+	for _, tombstone := range rangeTombstones {
+		writer.AddRangeTombstone(tombstone)
+	}
+
+	if err := writer.Finish(); err != nil {
+		writer.Abandon()
+		return fmt.Errorf("failed to flush memtable: %w", err)
+	}
+
+	return db.sstableManager.AddSSTable(filename, logNumber)
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+	"github.com/AmrMurad1/Go-Store/sstable"
+)
+
+func openTestEngine(t *testing.T, dir string, opts *Options) *Engine {
+	t.Helper()
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	db, err := NewEngineWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func assertValue(t *testing.T, db *Engine, key, want string) {
+	t.Helper()
+	got, err := db.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if got != want {
+		t.Fatalf("Get(%q) = %q, want %q", key, got, want)
+	}
+}
+
+func assertMissing(t *testing.T, db *Engine, key string) {
+	t.Helper()
+	if got, err := db.Get(key); err == nil {
+		t.Fatalf("Get(%q) = %q, want it deleted", key, got)
+	}
+}
+
+func TestFailedFlushKeepsMemtableAndWAL(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.MinBlobSize = 16
+	opts.MaxMemtableSize = 64
+	db, err := NewEngineWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// whichever number the flush gets, its blob file cannot be created
+	var blocked []string
+	for number := uint64(1); number < 100; number++ {
+		path := filepath.Join(dir, sstable.BlobFileName(number))
+		if err := os.Mkdir(path, 0755); err == nil {
+			blocked = append(blocked, path)
+		}
+	}
+
+	value := strings.Repeat("v", 100)
+	if err := db.Set("key", value); err == nil {
+		t.Fatal("Set did not report the failed flush")
+	}
+	assertValue(t, db, "key", value)
+	if tables, _ := filepath.Glob(filepath.Join(dir, "*.sst")); len(tables) != 0 {
+		t.Fatalf("failed flush left %v behind", tables)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range blocked {
+		os.Remove(path)
+	}
+	assertValue(t, openTestEngine(t, dir, opts), "key", value)
+}
+
+func writeExternalFile(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writer, err := sstable.NewSstFileWriter(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := writer.Put(shared.Key(key), []byte(entries[key])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIngestExternalFilesShadowsOlderWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestEngine(t, dir, nil)
+	if err := db.Set("a", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "nightly.sst")
+	writeExternalFile(t, path, map[string]string{"a": "bulk", "b": "bulk"})
+	if err := db.IngestExternalFiles([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, db, "a", "bulk")
+
+	// the next run of the job rewrites the file it ingested
+	writeExternalFile(t, path, map[string]string{"c": "next"})
+
+	if err := db.Set("b", "new"); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, db, "b", "new")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestEngine(t, dir, nil)
+	assertValue(t, db, "a", "bulk")
+	assertValue(t, db, "b", "new")
+	assertMissing(t, db, "c")
+}
+
+func TestIngestExternalFilesMovesOnRequest(t *testing.T) {
+	db := openTestEngine(t, t.TempDir(), nil)
+
+	path := filepath.Join(t.TempDir(), "bulk.sst")
+	writeExternalFile(t, path, map[string]string{"a": "bulk"})
+	if err := db.IngestExternalFilesWithOptions([]string{path}, &sstable.IngestOptions{MoveFiles: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("moved file still at its source path: %v", err)
+	}
+	assertValue(t, db, "a", "bulk")
+}
+
+func TestSecondEngineGetsErrLocked(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestEngine(t, dir, nil)
+
+	if _, err := NewEngine(dir); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second engine opened with %v, want ErrLocked", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	openTestEngine(t, dir, nil)
+}
+
+func drainIterator(t *testing.T, it *Iterator) []string {
+	t.Helper()
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key()+"="+it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestDeleteRangeHidesKeysInRange(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestEngine(t, dir, nil)
+	setAndFlush(t, db, "a", "1")
+	setAndFlush(t, db, "b", "2")
+	for _, key := range []string{"c", "d"} {
+		if err := db.Set(key, "3"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.DeleteRange("b", "d"); err != nil {
+		t.Fatal(err)
+	}
+	assertMissing(t, db, "b")
+	assertMissing(t, db, "c")
+	assertValue(t, db, "a", "1")
+	assertValue(t, db, "d", "3")
+
+	// keys written after the range was deleted are visible again
+	if err := db.Set("c", "4"); err != nil {
+		t.Fatal(err)
+	}
+	it, err := db.NewIterator("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(drainIterator(t, it), ","); got != "a=1,c=4,d=3" {
+		t.Fatalf("iterated %s, want a=1,c=4,d=3", got)
+	}
+	it.Close()
+
+	if err := db.CompactRange("", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openTestEngine(t, dir, nil)
+	assertMissing(t, db, "b")
+	assertValue(t, db, "c", "4")
+}
+
+func TestCallsAfterCloseReturnErrClosed(t *testing.T) {
+	db := openTestEngine(t, t.TempDir(), nil)
+	setAndFlush(t, db, "a", "1")
+	it, err := db.NewIterator("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Get after Close returned %v, want ErrClosed", err)
+	}
+	if err := db.Set("b", "2"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Set after Close returned %v, want ErrClosed", err)
+	}
+	if _, err := db.NewIterator("", ""); !errors.Is(err, ErrClosed) {
+		t.Fatalf("NewIterator after Close returned %v, want ErrClosed", err)
+	}
+	if err := db.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("second Close returned %v, want ErrClosed", err)
+	}
+
+	// an iterator left open across Close stops instead of reading closed tables
+	if it.Next() || !errors.Is(it.Err(), ErrClosed) {
+		t.Fatalf("iterator after Close returned %v, want ErrClosed", it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushRemovesFlushedWAL(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	db := openTestEngine(t, dir, nil)
+	for _, key := range []string{"a", "b"} {
+		setAndFlush(t, db, key, "1")
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(logs) != 1 {
+		t.Fatalf("found WALs %v after flushing, want only the current one", logs)
+	}
+	if strings.Contains(output.String(), "Warning") {
+		t.Fatalf("flushing logged a warning:\n%s", output.String())
+	}
+}
+
+func TestCompactRangeDoesNotBlockReadsAndWrites(t *testing.T) {
+	limiter := sstable.NewRateLimiter(0)
+	opts := DefaultOptions()
+	opts.CompressionPerLevel = []sstable.CompressionType{sstable.NoCompression}
+	opts.RateLimiter = limiter
+	db := openTestEngine(t, t.TempDir(), opts)
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 400; i++ {
+		if err := db.Set(fmt.Sprintf("k%05d", i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CompactRange("", ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 400; i += 40 {
+		if err := db.Set(fmt.Sprintf("k%05d", i), "new"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rewriting the bottom level now takes a couple of seconds
+	limiter.SetBytesPerSecond(16 * 1024)
+	compacted := make(chan error, 1)
+	go func() { compacted <- db.CompactRange("", "") }()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	assertValue(t, db, "k00040", "new")
+	if err := db.Set("during", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Get and Set waited %v for the manual compaction", elapsed)
+	}
+	select {
+	case err := <-compacted:
+		t.Fatalf("compaction finished too early to tell, with %v", err)
+	default:
+	}
+
+	limiter.SetBytesPerSecond(0)
+	if err := <-compacted; err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, db, "during", "1")
+}
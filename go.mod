@@ -3,6 +3,6 @@ module github.com/AmrMurad1/Go-Store
 go 1.24.5
 
 require (
-	github.com/klauspost/compress v1.18.0 // indirect
-	github.com/spaolacci/murmur3 v1.1.0 // indirect
+	github.com/klauspost/compress v1.18.0
+	github.com/spaolacci/murmur3 v1.1.0
 )
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/AmrMurad1/Go-Store/shared"
+	"github.com/AmrMurad1/Go-Store/sstable"
+)
+
+// Iterator walks the live keys of an Engine in ascending order. It sees the
+// data as of its creation and must be closed once done.
+type Iterator struct {
+	db     *Engine
+	merged *sstable.MergingIterator
+	end    shared.Key
+	entry  *shared.Entry
+	err    error
+}
+
+// NewIterator returns an iterator over the keys in [start, end). An empty
+// end iterates up to the last key.
+func (db *Engine) NewIterator(start, end string) (*Iterator, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	startKey := shared.Key(start)
+	var endKey shared.Key
+	if end != "" {
+		endKey = shared.Key(end)
+	}
+
+	tables, err := db.sstableManager.NewIterators(startKey, endKey)
+	if db.retryAfterCatchUp(err) {
+		tables, err = db.sstableManager.NewIterators(startKey, endKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	children := append([]sstable.Iterator{db.memtable.NewIterator(startKey, endKey)}, tables...)
+
+	merged, err := sstable.NewMergingIterator(children...)
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator{db: db, merged: merged, end: endKey}, nil
+}
+
+// Next moves to the next live key and reports whether there is one.
+func (it *Iterator) Next() bool {
+	it.entry = nil
+	if it.err == nil && it.db.closed.Load() {
+		it.err = ErrClosed
+	}
+	for it.err == nil {
+		entry, err := it.merged.Next()
+		if err != nil {
+			it.err = err
+			break
+		}
+		if entry == nil || (it.end != nil && entry.Key.Compare(it.end) >= 0) {
+			break
+		}
+		if !entry.Tombstone {
+			it.entry = entry
+			return true
+		}
+	}
+	return false
+}
+
+func (it *Iterator) Key() string {
+	return string(it.entry.Key)
+}
+
+func (it *Iterator) Value() string {
+	return string(it.entry.Value)
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) Close() error {
+	return it.merged.Close()
+}
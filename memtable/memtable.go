@@ -1,6 +1,7 @@
 package memtable
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,10 +15,21 @@ type Memtable struct {
 	skiplist *SkipList
 	wal      *Wal
 	size     int
+
+	rangeTombstones []shared.RangeTombstone
+}
+
+// LogFileName returns the name of the WAL file with the given file number.
+func LogFileName(number uint64) string {
+	return fmt.Sprintf("%06d.log", number)
 }
 
-func NewMemtable(walDir string) (*Memtable, error) {
-	wal, err := NewWal(walDir, "wal.log")
+// NewMemtable creates a memtable logging to the WAL numbered logNumber.
+// Older WALs numbered minLogNumber or above still hold unflushed writes and
+// are replayed into it; WALs below minLogNumber were already flushed and are
+// removed.
+func NewMemtable(walDir string, logNumber, minLogNumber uint64) (*Memtable, error) {
+	wal, err := NewWal(walDir, LogFileName(logNumber))
 	if err != nil {
 		return nil, err
 	}
@@ -29,14 +41,14 @@ func NewMemtable(walDir string) (*Memtable, error) {
 		size:     0,
 	}
 
-	if err := m.recover(walDir); err != nil {
+	if err := m.recover(walDir, minLogNumber); err != nil {
 		return nil, err
 	}
 
 	return m, nil
 }
 
-func (m *Memtable) recover(walDir string) error {
+func (m *Memtable) recover(walDir string, minLogNumber uint64) error {
 	files, err := os.ReadDir(walDir)
 	if err != nil {
 		return fmt.Errorf("could not read WAL directory: %w", err)
@@ -44,7 +56,15 @@ func (m *Memtable) recover(walDir string) error {
 
 	var walFiles []*Wal
 	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == ".log" {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".log" && filepath.Join(walDir, file.Name()) != m.wal.path {
+			var number uint64
+			if _, err := fmt.Sscanf(file.Name(), "%d.log", &number); err == nil && number < minLogNumber {
+				if err := os.Remove(filepath.Join(walDir, file.Name())); err != nil {
+					return fmt.Errorf("could not remove flushed WAL file %s: %w", file.Name(), err)
+				}
+				continue
+			}
+
 			oldWal, err := NewWal(walDir, file.Name())
 			if err != nil {
 
@@ -62,9 +82,7 @@ func (m *Memtable) recover(walDir string) error {
 		}
 
 		for _, entry := range entries {
-			sizeChange := m.skiplist.Set(shared.Entry{Key: shared.Key(entry.Key), Value: entry.Value})
-			m.size += sizeChange
-
+			m.apply(entry)
 			if err := m.wal.Append(entry); err != nil {
 				return fmt.Errorf("could not append to new WAL: %w", err)
 			}
@@ -78,18 +96,79 @@ func (m *Memtable) recover(walDir string) error {
 	return nil
 }
 
-func (m *Memtable) Set(key shared.Key, value []byte) error {
+// OpenReadOnly replays the WALs numbered minLogNumber or above into a
+// memtable that has no WAL of its own and must not be written to. Nothing in
+// walDir is changed, so it is safe while another process writes there.
+func OpenReadOnly(walDir string, minLogNumber uint64) (*Memtable, error) {
+	m := &Memtable{
+		mu:       &sync.RWMutex{},
+		skiplist: New(18, 0.5),
+	}
+
+	files, err := os.ReadDir(walDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read WAL directory: %w", err)
+	}
+
+	for _, file := range files {
+		var number uint64
+		if file.IsDir() || filepath.Ext(file.Name()) != ".log" {
+			continue
+		}
+		if _, err := fmt.Sscanf(file.Name(), "%d.log", &number); err != nil || number < minLogNumber {
+			continue
+		}
+
+		wal := &Wal{dir: walDir, path: filepath.Join(walDir, file.Name())}
+		entries, err := wal.Retrieve()
+		if errors.Is(err, os.ErrNotExist) {
+			// flushed and removed since the directory was read
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve entries from %s: %w", wal.path, err)
+		}
+		for _, entry := range entries {
+			m.apply(entry)
+		}
+	}
+
+	return m, nil
+}
+
+// apply adds a replayed WAL entry without logging it again.
+func (m *Memtable) apply(entry WALEntry) {
+	if entry.RangeDeletion {
+		m.addRangeTombstone(shared.RangeTombstone{
+			Start:   shared.Key(entry.Key),
+			End:     shared.Key(entry.Value),
+			Version: entry.Version,
+		})
+		return
+	}
+
+	m.size += m.skiplist.Set(shared.Entry{
+		Key:       shared.Key(entry.Key),
+		Value:     entry.Value,
+		Tombstone: entry.Tombstone,
+		Version:   entry.Version,
+	})
+}
+
+func (m *Memtable) Set(key shared.Key, value []byte, version int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	entry := shared.Entry{
-		Key:   key,
-		Value: value,
+		Key:     key,
+		Value:   value,
+		Version: version,
 	}
 
 	walEntry := WALEntry{
-		Key:   string(key),
-		Value: value,
+		Key:     string(key),
+		Value:   value,
+		Version: version,
 	}
 
 	if err := m.wal.Append(walEntry); err != nil {
@@ -108,7 +187,7 @@ func (m *Memtable) Get(key shared.Key) (shared.Entry, bool) {
 	return m.skiplist.Get(key)
 }
 
-func (m *Memtable) Delete(key shared.Key) error {
+func (m *Memtable) Delete(key shared.Key, version int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -116,11 +195,14 @@ func (m *Memtable) Delete(key shared.Key) error {
 		Key:       key,
 		Value:     nil,
 		Tombstone: true,
+		Version:   version,
 	}
 
 	walEntry := WALEntry{
-		Key:   string(key),
-		Value: nil,
+		Key:       string(key),
+		Value:     nil,
+		Tombstone: true,
+		Version:   version,
 	}
 
 	if err := m.wal.Append(walEntry); err != nil {
@@ -132,14 +214,129 @@ func (m *Memtable) Delete(key shared.Key) error {
 	return nil
 }
 
+// DeleteRange records a range tombstone deleting every key in [start, end)
+// with a version below version.
+func (m *Memtable) DeleteRange(start, end shared.Key, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	walEntry := WALEntry{
+		Key:           string(start),
+		Value:         end,
+		Version:       version,
+		RangeDeletion: true,
+	}
+
+	if err := m.wal.Append(walEntry); err != nil {
+		return err
+	}
+
+	m.addRangeTombstone(shared.RangeTombstone{Start: start, End: end, Version: version})
+	return nil
+}
+
+func (m *Memtable) addRangeTombstone(tombstone shared.RangeTombstone) {
+	m.rangeTombstones = append(m.rangeTombstones, tombstone)
+	m.size += len(tombstone.Start) + len(tombstone.End) + 8
+}
+
+// RangeTombstones returns the range tombstones written to the memtable.
+func (m *Memtable) RangeTombstones() []shared.RangeTombstone {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]shared.RangeTombstone(nil), m.rangeTombstones...)
+}
+
+// DeletedBelow returns the version below which entries of key are deleted
+// by the range tombstones of the memtable, or zero.
+func (m *Memtable) DeletedBelow(key shared.Key) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return shared.CoveringVersion(m.rangeTombstones, key)
+}
+
+// Overlaps reports whether the memtable holds an entry with a key in
+// [start, end].
+func (m *Memtable) Overlaps(start, end shared.Key) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.skiplist.LowerBound(start)
+	return ok && entry.Key.Compare(end) <= 0
+}
+
 func (m *Memtable) All() []shared.Entry {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	return m.skiplist.All()
 }
 
+// MaxVersion returns the highest sequence number held by the memtable.
+func (m *Memtable) MaxVersion() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	version := 0
+	for _, entry := range m.skiplist.All() {
+		version = max(version, entry.Version)
+	}
+	for _, tombstone := range m.rangeTombstones {
+		version = max(version, tombstone.Version)
+	}
+	return version
+}
+
+func (m *Memtable) Close() error {
+	if m.wal == nil {
+		return nil
+	}
+	return m.wal.Close()
+}
+
+// Discard closes and removes the WAL of a memtable that has been flushed,
+// without syncing it first.
+func (m *Memtable) Discard() error {
+	if m.wal == nil {
+		return nil
+	}
+	return m.wal.Delete()
+}
+
 func (m *Memtable) Size() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	return m.size
 }
+
+// Iterator walks a point-in-time copy of the memtable entries in key order.
+type Iterator struct {
+	entries         []shared.Entry
+	rangeTombstones []shared.RangeTombstone
+	pos             int
+}
+
+// NewIterator returns an iterator over the entries in [start, end). A nil
+// end means no upper bound.
+func (m *Memtable) NewIterator(start, end shared.Key) *Iterator {
+	return &Iterator{
+		entries:         m.skiplist.Scan(start, end),
+		rangeTombstones: m.RangeTombstones(),
+	}
+}
+
+func (it *Iterator) Next() (*shared.Entry, error) {
+	if it.pos >= len(it.entries) {
+		return nil, nil
+	}
+	entry := &it.entries[it.pos]
+	it.pos++
+	return entry, nil
+}
+
+func (it *Iterator) RangeTombstones() []shared.RangeTombstone {
+	return it.rangeTombstones
+}
+
+func (it *Iterator) Close() error {
+	it.entries = nil
+	return nil
+}
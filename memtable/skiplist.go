@@ -64,6 +64,7 @@ func (s *SkipList) Set(entry shared.Entry) int {
 		s.size += sizeChange
 		curr.next[0].Value = entry.Value
 		curr.next[0].Tombstone = entry.Tombstone
+		curr.next[0].Version = entry.Version
 		return sizeChange
 	}
 
@@ -139,6 +140,8 @@ func (s *SkipList) LowerBound(key shared.Key) (shared.Entry, bool) {
 	return shared.Entry{}, false
 }
 
+// Scan returns the entries in [start, end). A nil end scans to the last
+// entry.
 func (s *SkipList) Scan(start, end shared.Key) []shared.Entry {
 	var res []shared.Entry
 	curr := s.head
@@ -149,7 +152,7 @@ func (s *SkipList) Scan(start, end shared.Key) []shared.Entry {
 	}
 	curr = curr.next[0]
 
-	for curr != nil && shared.CompareKeys(curr.Key, end) < 0 {
+	for curr != nil && (end == nil || shared.CompareKeys(curr.Key, end) < 0) {
 		res = append(res, shared.Entry{
 			Key:       curr.Key,
 			Value:     curr.Value,
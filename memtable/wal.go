@@ -3,6 +3,7 @@ package memtable
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,16 +11,25 @@ import (
 	"sync"
 )
 
-const KeySize = 256 // Fixed key size
-
 type WALEntry struct {
-	Key   string
-	Value []byte
+	Key       string
+	Value     []byte
+	Tombstone bool
+	Version   int
+	// RangeDeletion marks a range tombstone deleting [Key, Value).
+	RangeDeletion bool
 }
 
+// entry kinds stored in the byte after the version
+const (
+	kindValue byte = iota
+	kindTombstone
+	kindRangeDeletion
+)
+
 type Wal struct {
 	mu     sync.Mutex
-	writer io.WriteCloser
+	writer *os.File
 	dir    string
 	path   string
 }
@@ -51,12 +61,20 @@ func (w *Wal) Append(entry WALEntry) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// Pad the key to KeySize
-	paddedKey := make([]byte, KeySize)
-	copy(paddedKey, []byte(entry.Key))
-
-	buf := make([]byte, 0, KeySize+4+len(entry.Value))
-	buf = append(buf, []byte(entry.Key)...)
+	// a record is the length-prefixed key, the version, the kind and the
+	// length-prefixed value
+	buf := make([]byte, 0, 4+len(entry.Key)+8+1+4+len(entry.Value))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entry.Key)))
+	buf = append(buf, entry.Key...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.Version))
+	switch {
+	case entry.RangeDeletion:
+		buf = append(buf, kindRangeDeletion)
+	case entry.Tombstone:
+		buf = append(buf, kindTombstone)
+	default:
+		buf = append(buf, kindValue)
+	}
 	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entry.Value))) // Value length
 	buf = append(buf, entry.Value...)
 
@@ -80,27 +98,48 @@ func (w *Wal) Retrieve() ([]WALEntry, error) {
 		return nil, err
 	}
 
-	mp := map[string][]byte{}
+	mp := map[string]WALEntry{}
+	var rangeDeletions []WALEntry
 
 	for buf.Len() > 0 {
-		keyBytes := make([]byte, KeySize)
-		buf.Read(keyBytes)
+		// a record still being appended by another writer is left for later
+		if buf.Len() < 4 {
+			break
+		}
+		keyLen := binary.LittleEndian.Uint32(buf.Next(4))
+		if uint64(buf.Len()) < uint64(keyLen)+8+1+4 {
+			break
+		}
+		key := string(buf.Next(int(keyLen)))
+
+		versionBytes := make([]byte, 8)
+		buf.Read(versionBytes)
+		version := binary.LittleEndian.Uint64(versionBytes)
+
+		kind, _ := buf.ReadByte()
 
 		lenBytes := make([]byte, 4)
 		buf.Read(lenBytes)
 		valueLen := binary.LittleEndian.Uint32(lenBytes)
+		if uint32(buf.Len()) < valueLen {
+			break
+		}
 
 		value := make([]byte, valueLen)
 		buf.Read(value)
 
-		mp[string(keyBytes)] = value
+		if kind == kindRangeDeletion {
+			rangeDeletions = append(rangeDeletions, WALEntry{Key: key, Value: value, Version: int(version), RangeDeletion: true})
+			continue
+		}
+		mp[key] = WALEntry{Key: key, Value: value, Tombstone: kind == kindTombstone, Version: int(version)}
 	}
 
 	var entries []WALEntry
-	for k, v := range mp {
-		entries = append(entries, WALEntry{Key: k, Value: v})
+	for _, entry := range mp {
+		entries = append(entries, entry)
 	}
-	return entries, nil
+	return append(entries, rangeDeletions...), nil
 }
 
 func (w *Wal) Clear() error {
@@ -110,11 +149,20 @@ func (w *Wal) Clear() error {
 	return os.Truncate(w.path, 0)
 }
 
+// Close syncs the log to disk and closes it.
 func (w *Wal) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
+	if err := w.writer.Sync(); err != nil {
+		w.writer.Close()
+		return fmt.Errorf("WAL %q cannot sync file: %w", w.path, err)
+	}
 	return w.writer.Close()
 }
 
+// Delete closes and removes the log. A log already removed, as obsolete
+// file cleanup does once it has been flushed, is not an error.
 func (w *Wal) Delete() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -122,5 +170,8 @@ func (w *Wal) Delete() error {
 	if err := w.writer.Close(); err != nil {
 		return err
 	}
-	return os.Remove(w.path)
+	if err := os.Remove(w.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,62 @@
+package memtable
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func TestWALRoundTripsEntries(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewMemtable(dir, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("k", 300)
+	if err := m.Set(shared.Key(long), []byte("long"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set(shared.Key("nul\x00"), []byte("nul"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(shared.Key("gone"), 3); err != nil {
+		t.Fatal(err)
+	}
+	rangeStart := strings.Repeat("r", 300) + "a"
+	if err := m.DeleteRange(shared.Key(rangeStart), shared.Key("s"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := NewMemtable(dir, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+
+	for key, want := range map[string]string{long: "long", "nul\x00": "nul"} {
+		entry, ok := recovered.Get(shared.Key(key))
+		if !ok || string(entry.Value) != want {
+			t.Fatalf("key of %d bytes recovered as %q, %v, want %q", len(key), entry.Value, ok, want)
+		}
+	}
+	if _, ok := recovered.Get(shared.Key(long[:256])); ok {
+		t.Fatal("a truncated key was recovered")
+	}
+	if _, ok := recovered.Get(shared.Key("nul")); ok {
+		t.Fatal("a key with its trailing NUL stripped was recovered")
+	}
+	if entry, ok := recovered.Get(shared.Key("gone")); !ok || !entry.Tombstone || entry.Version != 3 {
+		t.Fatalf("tombstone recovered as %+v, %v", entry, ok)
+	}
+
+	tombstones := recovered.RangeTombstones()
+	if len(tombstones) != 1 || string(tombstones[0].Start) != rangeStart ||
+		string(tombstones[0].End) != "s" || tombstones[0].Version != 4 {
+		t.Fatalf("range tombstones recovered as %+v", tombstones)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AmrMurad1/Go-Store/memtable"
+	"github.com/AmrMurad1/Go-Store/sstable"
+)
+
+// secondaryCatchUpAttempts bounds how often a catch-up starts over because
+// the primary flushed while its WALs were being read.
+const secondaryCatchUpAttempts = 3
+
+// OpenAsSecondary opens dir for reads while a primary engine keeps writing
+// to it. Every SecondaryCatchUpInterval the secondary replays the manifest
+// and WALs of the primary to see its latest writes. Nothing in dir is
+// written; tables the primary deletes after a compaction are dropped from
+// view at the next catch-up.
+func OpenAsSecondary(dir string) (*Engine, error) {
+	return OpenAsSecondaryWithOptions(dir, DefaultOptions())
+}
+
+func OpenAsSecondaryWithOptions(dir string, opts *Options) (*Engine, error) {
+	db := &Engine{
+		dir:             dir,
+		lock:            &sync.Mutex{},
+		maxMemtableSize: opts.MaxMemtableSize,
+		readOnly:        true,
+		stop:            make(chan struct{}),
+	}
+
+	var err error
+	db.sstableManager, err = sstable.NewReadOnlySSManager(dir, &opts.Options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s as secondary: %w", dir, err)
+	}
+
+	if err := db.TryCatchUpWithPrimary(); err != nil {
+		db.sstableManager.Close()
+		return nil, err
+	}
+
+	if opts.SecondaryCatchUpInterval > 0 {
+		db.background.Add(1)
+		go db.catchUpPeriodically(opts.SecondaryCatchUpInterval)
+	}
+	return db, nil
+}
+
+// TryCatchUpWithPrimary makes the writes the primary has logged so far
+// visible to a secondary.
+func (db *Engine) TryCatchUpWithPrimary() error {
+	if db.stop == nil {
+		return fmt.Errorf("only a secondary can catch up with a primary")
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	return db.tryCatchUp()
+}
+
+// tryCatchUp is TryCatchUpWithPrimary for callers holding db.lock.
+func (db *Engine) tryCatchUp() error {
+	var err error
+	for attempt := 0; attempt < secondaryCatchUpAttempts; attempt++ {
+		var caughtUp bool
+		if caughtUp, err = db.catchUp(); err == nil && caughtUp {
+			return nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("primary kept flushing")
+	}
+	return fmt.Errorf("failed to catch up with primary: %w", err)
+}
+
+// catchUp installs the current version of the primary and rebuilds the
+// memtable from the WALs it has not flushed. It reports false when the
+// primary flushed in the meantime, since the WAL holding the flushed writes
+// may already be gone.
+func (db *Engine) catchUp() (bool, error) {
+	if err := db.sstableManager.Refresh(); err != nil {
+		return false, err
+	}
+	logNumber := db.sstableManager.LogNumber()
+
+	mem, err := memtable.OpenReadOnly(db.dir, logNumber)
+	if err != nil {
+		return false, err
+	}
+
+	if err := db.sstableManager.Refresh(); err != nil {
+		return false, err
+	}
+	if db.sstableManager.LogNumber() != logNumber {
+		return false, nil
+	}
+
+	db.memtable = mem
+	db.sequence = max(db.sstableManager.MaxSequence(), db.memtable.MaxVersion())
+	return true, nil
+}
+
+// retryAfterCatchUp reports whether a read that failed with err should be
+// repeated after catching up: a secondary loses tables the primary deleted
+// after compacting them away.
+func (db *Engine) retryAfterCatchUp(err error) bool {
+	if db.stop == nil || !errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	if err := db.tryCatchUp(); err != nil {
+		log.Printf("Warning: %v", err)
+		return false
+	}
+	return true
+}
+
+func (db *Engine) catchUpPeriodically(interval time.Duration) {
+	defer db.background.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			if err := db.TryCatchUpWithPrimary(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func openTestSecondary(t *testing.T, dir string, interval time.Duration) *Engine {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.SecondaryCatchUpInterval = interval
+	db, err := OpenAsSecondaryWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSecondaryCatchesUpWithPrimary(t *testing.T) {
+	dir := t.TempDir()
+	primary := openTestEngine(t, dir, nil)
+	setAndFlush(t, primary, "flushed", "1")
+	if err := primary.Set("logged", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	secondary := openTestSecondary(t, dir, 0)
+	assertValue(t, secondary, "flushed", "1")
+	assertValue(t, secondary, "logged", "2")
+	if err := secondary.Set("k", "v"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Set on a secondary returned %v, want ErrReadOnly", err)
+	}
+
+	if err := primary.Set("later", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Delete("flushed"); err != nil {
+		t.Fatal(err)
+	}
+	assertMissing(t, secondary, "later")
+
+	if err := secondary.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, secondary, "later", "3")
+	assertMissing(t, secondary, "flushed")
+}
+
+func TestSecondaryReadsAfterPrimaryCompacts(t *testing.T) {
+	dir := t.TempDir()
+	primary := openTestEngine(t, dir, nil)
+	setAndFlush(t, primary, "a", "1")
+
+	secondary := openTestSecondary(t, dir, 0)
+	assertValue(t, secondary, "a", "1")
+
+	// the tables the secondary knows of are compacted away and deleted
+	setAndFlush(t, primary, "b", "2")
+	assertValue(t, secondary, "a", "1")
+}
+
+func TestSecondaryCatchesUpPeriodically(t *testing.T) {
+	dir := t.TempDir()
+	primary := openTestEngine(t, dir, nil)
+	secondary := openTestSecondary(t, dir, 10*time.Millisecond)
+
+	if err := primary.Set("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if value, err := secondary.Get("a"); err == nil && value == "1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("secondary never caught up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := secondary.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := secondary.TryCatchUpWithPrimary(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("catch-up after Close returned %v, want ErrClosed", err)
+	}
+}
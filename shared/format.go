@@ -1,8 +1,16 @@
 package shared
 
 const (
-	MagicNumber uint64 = 0xDEADBEEFCAFE
-	FooterSize  int    = 44
+	// MagicNumber ends every table with a versioned footer. Tables ending
+	// in LegacyMagicNumber were written before the layout was versioned and
+	// have a LegacyFooterSize footer; they are read as format version 0.
+	MagicNumber       uint64 = 0x5354424C45564552
+	LegacyMagicNumber uint64 = 0xDEADBEEFCAFE
+	// FormatVersion is the table layout written by this version. It changes
+	// whenever the footer, meta block or data block encoding does.
+	FormatVersion    uint32 = 1
+	FooterSize       int    = 72
+	LegacyFooterSize int    = 44
 )
 
 type IndexRecord struct {
@@ -16,6 +24,34 @@ type MetaBlock struct {
 	MinKey     Key
 	MaxKey     Key
 	Timestamp  int64
+	// DictionarySize is zero when the table has no zstd dictionary.
+	// DictionaryGain is how many times smaller sampled blocks compress
+	// with the dictionary than without it.
+	DictionarySize uint32
+	DictionaryGain float64
+	// RawDataSize is the uncompressed size of all data blocks, DataSize
+	// their size on disk.
+	RawDataSize    uint64
+	DataSize       uint64
+	DataBlockCount uint64
+	TombstoneCount uint64
+	RangeDelCount  uint64
+	RawKeySize     uint64
+	RawValueSize   uint64
+	Compression    uint8
+	MinSequence    uint64
+	MaxSequence    uint64
+	// GlobalSequence, when set, is the version of every entry of an
+	// ingested table, whatever version its data blocks hold.
+	GlobalSequence uint64
+	// IndexPartitions and FilterPartitions are zero unless the table was
+	// written with a partitioned index or filter.
+	IndexPartitions  uint32
+	FilterPartitions uint32
+	// BlobReferences holds, per blob file number, how many value bytes of
+	// that file the table references.
+	BlobReferences map[uint64]uint64
+	UserProperties map[string]string
 }
 
 type Footer struct {
@@ -25,5 +61,10 @@ type Footer struct {
 	IndexBlockSize   uint32
 	FilterOffset     int64
 	FilterSize       uint32
+	DictionaryOffset int64
+	DictionarySize   uint32
+	RangeDelOffset   int64
+	RangeDelSize     uint32
+	FormatVersion    uint32
 	Magic            uint64
 }
@@ -13,8 +13,36 @@ type Entry struct {
 	Value     []byte
 	Tombstone bool
 	Version   int
+	// BlobIndex marks a Value that is a reference into a blob file rather
+	// than the value itself.
+	BlobIndex bool
 }
 
 func CompareKeys(k1, k2 Key) int {
 	return bytes.Compare(k1, k2)
 }
+
+// RangeTombstone deletes every key in [Start, End) written before it, that
+// is with a lower version.
+type RangeTombstone struct {
+	Start   Key
+	End     Key
+	Version int
+}
+
+func (t RangeTombstone) Contains(key Key) bool {
+	return key.Compare(t.Start) >= 0 && key.Compare(t.End) < 0
+}
+
+// CoveringVersion returns the highest version among the tombstones that
+// contain key, or zero when none does. An entry of key is deleted when its
+// version is below it.
+func CoveringVersion(tombstones []RangeTombstone, key Key) int {
+	version := 0
+	for _, tombstone := range tombstones {
+		if tombstone.Contains(key) {
+			version = max(version, tombstone.Version)
+		}
+	}
+	return version
+}
@@ -0,0 +1,195 @@
+package sstable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// blobReferenceSize is the size of an encoded blobReference.
+const blobReferenceSize = 20
+
+// BlobFileName returns the name of the blob file with the given number. A
+// table stores its separated values in the blob file sharing its number.
+func BlobFileName(number uint64) string {
+	return fmt.Sprintf("%06d.blob", number)
+}
+
+// blobReference locates a value in a blob file. It is stored as the value
+// of entries whose BlobIndex is set.
+type blobReference struct {
+	fileNumber uint64
+	offset     uint64
+	size       uint32
+}
+
+func (r blobReference) encode() []byte {
+	buf := make([]byte, 0, blobReferenceSize)
+	buf = binary.LittleEndian.AppendUint64(buf, r.fileNumber)
+	buf = binary.LittleEndian.AppendUint64(buf, r.offset)
+	return binary.LittleEndian.AppendUint32(buf, r.size)
+}
+
+func decodeBlobReference(data []byte) (blobReference, error) {
+	if len(data) != blobReferenceSize {
+		return blobReference{}, fmt.Errorf("invalid blob reference of %d bytes", len(data))
+	}
+	return blobReference{
+		fileNumber: binary.LittleEndian.Uint64(data),
+		offset:     binary.LittleEndian.Uint64(data[8:]),
+		size:       binary.LittleEndian.Uint32(data[16:]),
+	}, nil
+}
+
+// blobWriter appends values to a blob file. The file holds nothing but the
+// values, so its size is the number of value bytes it stores.
+type blobWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	number uint64
+	offset uint64
+}
+
+func newBlobWriter(path string, number uint64, config *SSTableConfig) (*blobWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var output io.Writer = file
+	if config.RateLimiter != nil {
+		output = &rateLimitedWriter{w: file, limiter: config.RateLimiter, priority: config.IOPriority}
+	}
+	return &blobWriter{file: file, writer: bufio.NewWriter(output), number: number}, nil
+}
+
+func (w *blobWriter) add(value []byte) (blobReference, error) {
+	if _, err := w.writer.Write(value); err != nil {
+		return blobReference{}, err
+	}
+	ref := blobReference{fileNumber: w.number, offset: w.offset, size: uint32(len(value))}
+	w.offset += uint64(len(value))
+	return ref, nil
+}
+
+func (w *blobWriter) finish() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to sync blob file: %w", err)
+	}
+	return w.file.Close()
+}
+
+// resolveBlob returns a copy of entry holding the value its blob reference
+// points to in dir.
+func resolveBlob(dir string, entry *shared.Entry) (*shared.Entry, error) {
+	reader := &blobReader{dir: dir}
+	defer reader.close()
+	return reader.resolve(entry)
+}
+
+// blobReader reads values from the blob files of dir. The file read last
+// stays open, since consecutive entries of a table mostly share one.
+type blobReader struct {
+	dir    string
+	number uint64
+	file   *os.File
+}
+
+// resolve returns a copy of entry holding the value its blob reference
+// points to.
+func (r *blobReader) resolve(entry *shared.Entry) (*shared.Entry, error) {
+	ref, err := decodeBlobReference(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.file == nil || r.number != ref.fileNumber {
+		r.close()
+		file, err := os.Open(filepath.Join(r.dir, BlobFileName(ref.fileNumber)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open blob file: %w", err)
+		}
+		r.file, r.number = file, ref.fileNumber
+	}
+
+	value := make([]byte, ref.size)
+	if _, err := r.file.ReadAt(value, int64(ref.offset)); err != nil {
+		return nil, fmt.Errorf("failed to read blob of %q: %w", entry.Key, err)
+	}
+
+	resolved := *entry
+	resolved.Value = value
+	resolved.BlobIndex = false
+	return &resolved, nil
+}
+
+func (r *blobReader) close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// blobFilesToCollect returns the blob files referenced by tables whose share
+// of unreferenced bytes has reached BlobGarbageCollectionRatio. Compaction
+// moves the values still referenced out of them so they can be deleted. With
+// MinBlobSize at zero every referenced file is collected, which brings the
+// values back into the tables.
+func (m *SSManager) blobFilesToCollect(tables []*tableFile) map[uint64]bool {
+	collect := make(map[uint64]bool)
+	if m.opts.MinBlobSize > 0 && m.opts.BlobGarbageCollectionRatio <= 0 {
+		return collect
+	}
+
+	live := m.liveBlobBytes()
+	for _, table := range tables {
+		for number := range table.meta.BlobReferences {
+			if collect[number] {
+				continue
+			}
+			if m.opts.MinBlobSize <= 0 {
+				collect[number] = true
+				continue
+			}
+			info, err := os.Stat(filepath.Join(m.dir, BlobFileName(number)))
+			if err != nil || info.Size() == 0 {
+				continue
+			}
+			garbage := 1 - float64(live[number])/float64(info.Size())
+			collect[number] = garbage >= m.opts.BlobGarbageCollectionRatio
+		}
+	}
+	return collect
+}
+
+// blobCollected reports whether the blob file entry refers to is in collect.
+func blobCollected(collect map[uint64]bool, entry *shared.Entry) bool {
+	ref, err := decodeBlobReference(entry.Value)
+	return err != nil || collect[ref.fileNumber]
+}
+
+// liveBlobBytes sums, per blob file, the bytes referenced by the tables of
+// the current version.
+func (m *SSManager) liveBlobBytes() map[uint64]uint64 {
+	live := make(map[uint64]uint64)
+	for _, level := range m.sstables {
+		for _, table := range level {
+			for number, size := range table.meta.BlobReferences {
+				live[number] += size
+			}
+		}
+	}
+	return live
+}
@@ -0,0 +1,92 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func TestBlobFileCollectedOnceMostlyGarbage(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MinBlobSize = 16
+	m := newTestManager(t, opts)
+	blob := func(key string, version int) shared.Entry {
+		return shared.Entry{Key: shared.Key(key), Value: []byte(fmt.Sprintf("%s-%032d", key, version)), Version: version}
+	}
+	old := addTable(t, m, 2, blob("a", 1), blob("b", 2), blob("c", 3), blob("d", 4))
+	newer := addTable(t, m, 1, blob("a", 5), blob("b", 6), blob("c", 7))
+	oldBlobs := filepath.Join(m.dir, BlobFileName(tableNumber(t, old)))
+	if len(old.meta.BlobReferences) != 1 {
+		t.Fatalf("table refers to %d blob files, want 1", len(old.meta.BlobReferences))
+	}
+
+	// the overwrites only become garbage once this compaction drops them
+	compactInto(t, m, 1, 2, newer)
+	if _, err := os.Stat(oldBlobs); err != nil {
+		t.Fatalf("blob file still referenced by d was deleted: %v", err)
+	}
+
+	compactInto(t, m, 2, 3, m.sstables[2]...)
+
+	if _, err := os.Stat(oldBlobs); !os.IsNotExist(err) {
+		t.Fatalf("blob file with 3 of 4 values overwritten was kept: %v", err)
+	}
+	for key, version := range map[string]int{"a": 5, "b": 6, "c": 7, "d": 4} {
+		entry, err := m.Get(shared.Key(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := blob(key, version).Value; entry == nil || string(entry.Value) != string(want) {
+			t.Fatalf("%s reads as %+v, want %q", key, entry, want)
+		}
+	}
+}
+
+func tableNumber(t *testing.T, table *tableFile) uint64 {
+	t.Helper()
+	number, ok := parseFileNumber(filepath.Base(table.path))
+	if !ok {
+		t.Fatalf("table %s has no file number", table.path)
+	}
+	return number
+}
+
+func TestIteratorKeepsBlobFileOpen(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MinBlobSize = 16
+	m := newTestManager(t, opts)
+	entries := []shared.Entry{}
+	for i, key := range []string{"a", "b", "c", "d"} {
+		entries = append(entries, shared.Entry{Key: shared.Key(key), Value: []byte(fmt.Sprintf("%s-%032d", key, i)), Version: i + 1})
+	}
+	table := addTable(t, m, 1, entries...)
+
+	iterators, err := m.NewIterators(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(iterators) != 1 {
+		t.Fatalf("got %d iterators, want 1", len(iterators))
+	}
+	it := iterators[0]
+	defer it.Close()
+
+	for i, want := range entries {
+		entry, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil || string(entry.Value) != string(want.Value) {
+			t.Fatalf("%s reads as %+v, want %q", want.Key, entry, want.Value)
+		}
+		// the blob file stays readable once the iterator has opened it
+		if i == 0 {
+			if err := os.Remove(filepath.Join(m.dir, BlobFileName(tableNumber(t, table)))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package sstable
+
+import (
+	"container/list"
+	"sync"
+)
+
+// BlockCache holds decoded blocks (data blocks, index partitions and filter
+// partitions) shared by every open table, evicting the least recently used
+// ones once their total charge exceeds the capacity in bytes. A nil
+// *BlockCache disables caching.
+type BlockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	used     int64
+	lru      *list.List
+	blocks   map[blockKey]*list.Element
+}
+
+type blockKey struct {
+	path   string
+	offset int64
+}
+
+type cachedBlock struct {
+	key    blockKey
+	value  any
+	charge int64
+}
+
+func NewBlockCache(capacity int64) *BlockCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &BlockCache{
+		capacity: capacity,
+		lru:      list.New(),
+		blocks:   make(map[blockKey]*list.Element),
+	}
+}
+
+func (c *BlockCache) get(key blockKey) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.blocks[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedBlock).value, true
+}
+
+func (c *BlockCache) put(key blockKey, value any, charge int) {
+	if c == nil || int64(charge) > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.blocks[key]; ok {
+		block := elem.Value.(*cachedBlock)
+		c.used += int64(charge) - block.charge
+		block.value = value
+		block.charge = int64(charge)
+		c.lru.MoveToFront(elem)
+	} else {
+		c.blocks[key] = c.lru.PushFront(&cachedBlock{key: key, value: value, charge: int64(charge)})
+		c.used += int64(charge)
+	}
+
+	for c.used > c.capacity {
+		elem := c.lru.Back()
+		block := elem.Value.(*cachedBlock)
+		c.lru.Remove(elem)
+		delete(c.blocks, block.key)
+		c.used -= block.charge
+	}
+}
+
+// evictTable drops every block of the table at path.
+func (c *BlockCache) evictTable(path string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.blocks {
+		if key.path == path {
+			c.used -= elem.Value.(*cachedBlock).charge
+			c.lru.Remove(elem)
+			delete(c.blocks, key)
+		}
+	}
+}
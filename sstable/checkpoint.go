@@ -0,0 +1,108 @@
+package sstable
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Checkpoint creates dir holding a copy of the database that can be opened
+// on its own. Tables and blob files never change once written, so they are
+// hard linked, falling back to a copy across file systems. The manifest,
+// CURRENT and the WALs that have not been flushed yet are copied. The caller
+// must keep the WALs from being written to until Checkpoint returns.
+func (m *SSManager) Checkpoint(dir string) error {
+	if m.readOnly {
+		return fmt.Errorf("cannot checkpoint a read-only manager")
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("checkpoint directory %s already exists", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	names, err := m.checkpointFiles()
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	for _, name := range names {
+		link := strings.HasSuffix(name, ".sst") || strings.HasSuffix(name, ".blob")
+		if err := linkOrCopy(filepath.Join(m.dir, name), filepath.Join(dir, name), link); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("failed to checkpoint %s: %w", name, err)
+		}
+	}
+
+	if err := setCurrentFile(dir, m.manifest.number); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// checkpointFiles lists the files of the current version: live tables,
+// the blob files they refer to, the manifest and the WALs still needed for
+// recovery.
+func (m *SSManager) checkpointFiles() ([]string, error) {
+	names := []string{manifestFileName(m.manifest.number)}
+	for _, level := range m.sstables {
+		for _, table := range level {
+			names = append(names, filepath.Base(table.path))
+		}
+	}
+	for number := range m.liveBlobBytes() {
+		names = append(names, BlobFileName(number))
+	}
+
+	files, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list database directory: %w", err)
+	}
+	for _, file := range files {
+		number, numbered := parseFileNumber(file.Name())
+		if strings.HasSuffix(file.Name(), ".log") && numbered && number >= m.logNumber {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+func linkOrCopy(src, dst string, link bool) error {
+	if link && os.Link(src, dst) == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile copies src to a new file dst and syncs it.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
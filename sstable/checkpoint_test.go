@@ -0,0 +1,42 @@
+package sstable
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func TestCheckpointOpensWithoutSource(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 2, put("a", 1), put("b", 2))
+	addTable(t, m, 0, put("c", 3))
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := m.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Checkpoint(dir); err == nil {
+		t.Fatal("checkpoint into an existing directory succeeded")
+	}
+
+	// later compactions in the source must not touch the checkpoint
+	compactInto(t, m, 0, 1, m.sstables[0]...)
+
+	checkpoint, err := NewSSManager(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.Close()
+
+	if len(checkpoint.sstables[0]) != 1 || len(checkpoint.sstables[2]) != 1 {
+		t.Fatalf("checkpoint has %d tables in level 0 and %d in level 2, want 1 and 1",
+			len(checkpoint.sstables[0]), len(checkpoint.sstables[2]))
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		entry, err := checkpoint.Get(shared.Key(key))
+		if err != nil || entry == nil || string(entry.Value) != key+"-value" {
+			t.Fatalf("%s reads as %+v, %v in the checkpoint", key, entry, err)
+		}
+	}
+}
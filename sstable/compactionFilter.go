@@ -0,0 +1,46 @@
+package sstable
+
+import "github.com/AmrMurad1/Go-Store/shared"
+
+// CompactionFilter lets applications rewrite data as compactions pass over
+// it, for example to expire records past their retention or to migrate old
+// value formats. Filter is called for every live entry a compaction keeps,
+// with the level it is written to, and must be safe to call repeatedly on
+// the same key since data is compacted more than once.
+type CompactionFilter interface {
+	Name() string
+	Filter(level int, key shared.Key, value []byte) (decision FilterDecision, newValue []byte)
+}
+
+type FilterDecision byte
+
+const (
+	// FilterKeep leaves the entry as it is.
+	FilterKeep FilterDecision = iota
+	// FilterRemove deletes the entry.
+	FilterRemove
+	// FilterChangeValue replaces the value with the returned one.
+	FilterChangeValue
+)
+
+// applyCompactionFilter runs filter on entry and returns what the compaction
+// should write instead, or nil to write nothing. A removed key that older
+// tables may still hold turns into a tombstone so those versions stay hidden.
+func applyCompactionFilter(filter CompactionFilter, level int, entry *shared.Entry, older []*tableFile) *shared.Entry {
+	if filter == nil || entry.Tombstone {
+		return entry
+	}
+
+	decision, newValue := filter.Filter(level, entry.Key, entry.Value)
+	switch decision {
+	case FilterRemove:
+		if !coversKey(older, entry.Key) {
+			return nil
+		}
+		return &shared.Entry{Key: entry.Key, Tombstone: true, Version: entry.Version}
+	case FilterChangeValue:
+		return &shared.Entry{Key: entry.Key, Value: newValue, Version: entry.Version}
+	default:
+		return entry
+	}
+}
@@ -0,0 +1,124 @@
+package sstable
+
+import (
+	"log"
+	"time"
+)
+
+// The background check for aged tables runs at most once a second and at
+// least once a minute.
+const (
+	minPeriodicCompactionCheckInterval = time.Second
+	maxPeriodicCompactionCheckInterval = time.Minute
+)
+
+// tombstoneDense reports whether point and range tombstones make up at least
+// TombstoneCompactionRatio of the table's entries.
+func (m *SSManager) tombstoneDense(table *tableFile) bool {
+	if m.opts.TombstoneCompactionRatio <= 0 {
+		return false
+	}
+	entries := table.meta.EntryCount + table.meta.RangeDelCount
+	tombstones := table.meta.TombstoneCount + table.meta.RangeDelCount
+	return entries > 0 && float64(tombstones) >= m.opts.TombstoneCompactionRatio*float64(entries)
+}
+
+// aged reports whether the table was written before cutoff.
+func (m *SSManager) aged(table *tableFile, cutoff time.Time) bool {
+	return m.opts.PeriodicCompactionAge > 0 && table.meta.Timestamp < cutoff.UnixNano()
+}
+
+// pickTriggeredCompaction returns a compaction for a table that is dense
+// with tombstones or was written before cutoff, even though no level is over
+// its target. Level 0 tables are not checked for tombstones since their
+// count compacts them soon enough. The table is always rewritten, never
+// moved, and tables of the last level are rewritten in place.
+func (m *SSManager) pickTriggeredCompaction(cutoff time.Time) *compaction {
+	if m.opts.CompactionStyle == UniversalCompaction {
+		return m.pickTriggeredUniversalCompaction(cutoff)
+	}
+
+	for level, tables := range m.sstables {
+		for _, table := range tables {
+			if !m.aged(table, cutoff) && (level == 0 || !m.tombstoneDense(table)) {
+				continue
+			}
+
+			c := &compaction{
+				level:       level,
+				outputLevel: level + 1,
+				inputs:      []*tableFile{table},
+				maxFileSize: m.opts.TargetFileSize,
+				rewrite:     true,
+			}
+			switch {
+			case level == 0:
+				c.inputs = m.sstables[0]
+			case level >= m.opts.NumLevels-1:
+				c.outputLevel = level
+				return c
+			}
+			minKey, maxKey := keyRange(c.inputs)
+			c.overlapping = m.overlappingFiles(c.outputLevel, minKey, maxKey)
+			return c
+		}
+	}
+	return nil
+}
+
+// pickTriggeredUniversalCompaction merges every run once the oldest one was
+// written before cutoff or tombstones make up TombstoneCompactionRatio of all
+// runs together. Only a merge that includes the oldest run can drop them.
+func (m *SSManager) pickTriggeredUniversalCompaction(cutoff time.Time) *compaction {
+	runs := m.sstables[0]
+	if len(runs) == 0 {
+		return nil
+	}
+
+	total := &tableFile{}
+	for _, run := range runs {
+		total.meta.EntryCount += run.meta.EntryCount
+		total.meta.TombstoneCount += run.meta.TombstoneCount
+		total.meta.RangeDelCount += run.meta.RangeDelCount
+	}
+	if !m.aged(runs[0], cutoff) && !m.tombstoneDense(total) {
+		return nil
+	}
+	return &compaction{level: 0, outputLevel: 0, inputs: runs, rewrite: true}
+}
+
+// scheduleCompaction wakes the background compaction goroutine. A wake-up
+// that is already pending covers this one.
+func (m *SSManager) scheduleCompaction() {
+	select {
+	case m.compactionScheduled <- struct{}{}:
+	default:
+	}
+}
+
+// runCompactions runs fixLevels whenever a flush or ingestion schedules it
+// and, with PeriodicCompactionAge set, on a timer so that aged tables are
+// compacted even while nothing is written. It returns once stop is closed.
+func (m *SSManager) runCompactions() {
+	defer m.background.Done()
+
+	var periodic <-chan time.Time
+	if m.opts.PeriodicCompactionAge > 0 {
+		interval := min(m.opts.PeriodicCompactionAge, maxPeriodicCompactionCheckInterval)
+		ticker := time.NewTicker(max(interval, minPeriodicCompactionCheckInterval))
+		defer ticker.Stop()
+		periodic = ticker.C
+	}
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.compactionScheduled:
+		case <-periodic:
+		}
+		if err := m.fixLevels(); err != nil {
+			log.Printf("Warning: background compaction failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,566 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func newTestManager(t *testing.T, opts *Options) *SSManager {
+	t.Helper()
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	m, err := NewSSManager(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func put(key string, version int) shared.Entry {
+	return shared.Entry{Key: shared.Key(key), Value: []byte(key + "-value"), Version: version}
+}
+
+func del(key string, version int) shared.Entry {
+	return shared.Entry{Key: shared.Key(key), Tombstone: true, Version: version}
+}
+
+// writeTestTable writes entries, given in key order, to a table at path.
+func writeTestTable(t *testing.T, path string, config *SSTableConfig, entries ...shared.Entry) {
+	t.Helper()
+	writer, err := NewBlockWriter(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := writer.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addTable writes entries, given in key order, to a new table in level.
+func addTable(t *testing.T, m *SSManager, level int, entries ...shared.Entry) *tableFile {
+	t.Helper()
+	return addTableWithRanges(t, m, level, nil, entries...)
+}
+
+func addTableWithRanges(t *testing.T, m *SSManager, level int, tombstones []shared.RangeTombstone, entries ...shared.Entry) *tableFile {
+	t.Helper()
+	path := filepath.Join(m.dir, TableFileName(m.NewFileNumber()))
+	writer, err := NewBlockWriter(path, m.LevelConfig(level))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := writer.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tombstone := range tombstones {
+		writer.AddRangeTombstone(tombstone)
+	}
+	if err := writer.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := m.loadTableFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := &versionEdit{}
+	edit.addFile(level, table)
+	if err := m.logAndApply(edit); err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+// compactInto rewrites inputs from level into outputLevel, together with the
+// tables of outputLevel they overlap.
+func compactInto(t *testing.T, m *SSManager, level, outputLevel int, inputs ...*tableFile) {
+	t.Helper()
+	minKey, maxKey := keyRange(inputs)
+	c := &compaction{
+		level:       level,
+		outputLevel: outputLevel,
+		inputs:      inputs,
+		overlapping: m.overlappingFiles(outputLevel, minKey, maxKey),
+		rewrite:     true,
+	}
+	if outputLevel == level {
+		c.overlapping = nil
+	} else {
+		c.maxFileSize = m.opts.TargetFileSize
+	}
+	if err := m.runCompaction(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertDeleted(t *testing.T, m *SSManager, key string) {
+	t.Helper()
+	entry, err := m.Get(shared.Key(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Fatalf("%s was deleted but reads as %q", key, entry.Value)
+	}
+}
+
+func deleteRange(start, end string, version int) []shared.RangeTombstone {
+	return []shared.RangeTombstone{{Start: shared.Key(start), End: shared.Key(end), Version: version}}
+}
+
+func rangeDeletions(m *SSManager, level int) uint64 {
+	var count uint64
+	if level < len(m.sstables) {
+		for _, table := range m.sstables[level] {
+			count += table.meta.RangeDelCount
+		}
+	}
+	return count
+}
+
+func tombstones(m *SSManager, level int) uint64 {
+	var count uint64
+	if level < len(m.sstables) {
+		for _, table := range m.sstables[level] {
+			count += table.meta.TombstoneCount
+		}
+	}
+	return count
+}
+
+func TestTombstoneKeptWhileDeeperLevelHoldsKey(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 3, put("k", 1))
+	deletion := addTable(t, m, 1, del("k", 2))
+
+	// level 2 is empty, which used to make it look like the last level
+	compactInto(t, m, 1, 2, deletion)
+
+	if got := tombstones(m, 2); got != 1 {
+		t.Fatalf("level 2 holds %d tombstones, want 1", got)
+	}
+	assertDeleted(t, m, "k")
+}
+
+func TestTombstoneShadowsOverlappingOutputLevel(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 2, put("a", 1), put("k", 2), put("z", 3))
+	deletion := addTable(t, m, 1, del("k", 4))
+
+	compactInto(t, m, 1, 2, deletion)
+
+	if got := tombstones(m, 2); got != 0 {
+		t.Fatalf("level 2 holds %d tombstones, want 0 once it absorbed the older value", got)
+	}
+	assertDeleted(t, m, "k")
+	for _, key := range []string{"a", "z"} {
+		if entry, _ := m.Get(shared.Key(key)); entry == nil {
+			t.Fatalf("%s was lost by the compaction", key)
+		}
+	}
+}
+
+func TestTombstoneDroppedWhenDeeperTablesDoNotCoverKey(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 3, put("x", 1), put("y", 2))
+	deletion := addTable(t, m, 1, del("b", 3))
+
+	compactInto(t, m, 1, 2, deletion)
+
+	if len(m.sstables[2]) != 0 {
+		t.Fatalf("level 2 holds %d tables, want none", len(m.sstables[2]))
+	}
+	assertDeleted(t, m, "b")
+}
+
+func TestTombstoneKeptOverOlderUniversalRuns(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CompactionStyle = UniversalCompaction
+	m := newTestManager(t, opts)
+
+	addTable(t, m, 0, put("k", 1))
+	middle := addTable(t, m, 0, put("m", 2))
+	newest := addTable(t, m, 0, del("k", 3))
+
+	compactInto(t, m, 0, 0, middle, newest)
+
+	if got := tombstones(m, 0); got != 1 {
+		t.Fatalf("level 0 holds %d tombstones, want 1", got)
+	}
+	assertDeleted(t, m, "k")
+
+	compactInto(t, m, 0, 0, m.sstables[0]...)
+
+	if got := tombstones(m, 0); got != 0 {
+		t.Fatalf("level 0 holds %d tombstones after a full merge, want 0", got)
+	}
+	assertDeleted(t, m, "k")
+}
+
+func TestDeleteThenCompactSurvivesReopen(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 3, put("k", 1))
+	deletion := addTable(t, m, 1, del("k", 2))
+	compactInto(t, m, 1, 2, deletion)
+
+	dir := m.dir
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewSSManager(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	assertDeleted(t, reopened, "k")
+}
+
+func TestIteratorKeepsItsViewAcrossCompaction(t *testing.T) {
+	m := newTestManager(t, nil)
+	value := addTable(t, m, 2, put("k", 1))
+	deletion := addTable(t, m, 1, del("k", 2))
+
+	iterators, err := m.NewIterators(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := NewMergingIterator(iterators...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer merged.Close()
+
+	compactInto(t, m, 1, 2, deletion)
+	if len(m.sstables[2]) != 0 {
+		t.Fatalf("level 2 holds %d tables, want none", len(m.sstables[2]))
+	}
+
+	for _, table := range []*tableFile{value, deletion} {
+		if _, err := os.Stat(table.path); err != nil {
+			t.Fatalf("table read by an open iterator was deleted: %v", err)
+		}
+	}
+
+	entry, err := merged.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || string(entry.Key) != "k" || !entry.Tombstone {
+		t.Fatalf("iterator returned %+v, want the tombstone of k", entry)
+	}
+}
+
+func TestRangeTombstoneKeptWhileDeeperLevelHoldsKeys(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 3, put("b", 1), put("d", 2))
+	deletion := addTableWithRanges(t, m, 1, deleteRange("a", "c", 3), put("c", 4))
+
+	compactInto(t, m, 1, 2, deletion)
+
+	if got := rangeDeletions(m, 2); got != 1 {
+		t.Fatalf("level 2 holds %d range tombstones, want 1", got)
+	}
+	assertDeleted(t, m, "b")
+	if entry, _ := m.Get(shared.Key("d")); entry == nil {
+		t.Fatal("d lies outside the deleted range but was lost")
+	}
+}
+
+func TestRangeTombstoneDroppedAtBottom(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 2, put("b", 1), put("x", 2))
+	deletion := addTableWithRanges(t, m, 1, deleteRange("a", "c", 3))
+
+	compactInto(t, m, 1, 2, deletion)
+
+	if got := rangeDeletions(m, 2); got != 0 {
+		t.Fatalf("level 2 holds %d range tombstones, want 0 at the bottom", got)
+	}
+	assertDeleted(t, m, "b")
+	if entry, _ := m.Get(shared.Key("x")); entry == nil {
+		t.Fatal("x lies outside the deleted range but was lost")
+	}
+}
+
+func TestRangeTombstoneSplitAcrossOutputs(t *testing.T) {
+	opts := DefaultOptions()
+	opts.TargetFileSize = 1
+	m := newTestManager(t, opts)
+	addTable(t, m, 3, put("a", 1), put("m", 2), put("z", 3))
+	deletion := addTableWithRanges(t, m, 1, deleteRange("a", "zz", 4), put("b", 5), put("n", 6))
+
+	compactInto(t, m, 1, 2, deletion)
+
+	outputs := m.sstables[2]
+	if len(outputs) != 2 {
+		t.Fatalf("compaction wrote %d tables, want 2", len(outputs))
+	}
+	if outputs[0].meta.MaxKey.Compare(outputs[1].meta.MinKey) > 0 {
+		t.Fatalf("outputs overlap: %q > %q", outputs[0].meta.MaxKey, outputs[1].meta.MinKey)
+	}
+	for _, key := range []string{"a", "m", "z"} {
+		assertDeleted(t, m, key)
+	}
+	for _, key := range []string{"b", "n"} {
+		if entry, _ := m.Get(shared.Key(key)); entry == nil {
+			t.Fatalf("%s was written after the range deletion but was lost", key)
+		}
+	}
+}
+
+type retentionFilter struct{}
+
+func (retentionFilter) Name() string { return "retention" }
+
+func (retentionFilter) Filter(level int, key shared.Key, value []byte) (FilterDecision, []byte) {
+	switch string(key) {
+	case "expired":
+		return FilterRemove, nil
+	case "legacy":
+		return FilterChangeValue, []byte("migrated")
+	}
+	return FilterKeep, nil
+}
+
+func TestCompactionFilterRemovesAndRewrites(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CompactionFilter = retentionFilter{}
+	m := newTestManager(t, opts)
+	addTable(t, m, 3, put("expired", 1))
+	inputs := addTable(t, m, 1, put("expired", 2), put("kept", 3), put("legacy", 4))
+
+	compactInto(t, m, 1, 2, inputs)
+
+	// the older version in level 3 must stay hidden behind a tombstone
+	if got := tombstones(m, 2); got != 1 {
+		t.Fatalf("level 2 holds %d tombstones, want 1", got)
+	}
+	assertDeleted(t, m, "expired")
+
+	entry, err := m.Get(shared.Key("legacy"))
+	if err != nil || entry == nil || string(entry.Value) != "migrated" {
+		t.Fatalf("legacy reads as %+v, %v; want the migrated value", entry, err)
+	}
+	if entry, _ := m.Get(shared.Key("kept")); entry == nil || string(entry.Value) != "kept-value" {
+		t.Fatalf("kept reads as %+v", entry)
+	}
+}
+
+func TestCompactRangeReachesBottomLevel(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 3, put("a", 1), put("k", 2), put("z", 3))
+	addTable(t, m, 1, del("k", 4), put("m", 5))
+	addTable(t, m, 0, del("a", 6))
+	addTableWithRanges(t, m, 0, deleteRange("x", "zz", 7))
+
+	if err := m.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for level := 0; level < 3; level++ {
+		if len(m.sstables[level]) != 0 {
+			t.Fatalf("level %d holds %d tables, want none", level, len(m.sstables[level]))
+		}
+	}
+	if got := tombstones(m, 3) + rangeDeletions(m, 3); got != 0 {
+		t.Fatalf("bottom level holds %d tombstones, want 0", got)
+	}
+	for _, key := range []string{"a", "k", "z"} {
+		assertDeleted(t, m, key)
+	}
+	if entry, _ := m.Get(shared.Key("m")); entry == nil {
+		t.Fatal("m was lost by the manual compaction")
+	}
+}
+
+func TestCompactRangeLeavesOtherKeysInPlace(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 2, put("a", 1))
+	addTable(t, m, 1, put("b", 2))
+	outside := addTable(t, m, 1, put("x", 3))
+
+	if err := m.CompactRange(shared.Key("a"), shared.Key("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.sstables[1]) != 1 || m.sstables[1][0] != outside {
+		t.Fatalf("level 1 holds %d tables, want only the one outside the range", len(m.sstables[1]))
+	}
+	if len(m.sstables[2]) != 2 {
+		t.Fatalf("level 2 holds %d tables, want 2", len(m.sstables[2]))
+	}
+}
+
+func TestTrivialMoveKeepsTables(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 2, put("a", 1), put("c", 2))
+	moved := addTable(t, m, 1, put("d", 3), put("f", 4))
+
+	c := &compaction{level: 1, outputLevel: 2, inputs: []*tableFile{moved}}
+	if err := m.runCompaction(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.sstables[1]) != 0 || len(m.sstables[2]) != 2 {
+		t.Fatalf("levels 1 and 2 hold %d and %d tables, want 0 and 2", len(m.sstables[1]), len(m.sstables[2]))
+	}
+	if m.sstables[2][1] != moved {
+		t.Fatal("the table was rewritten instead of moved")
+	}
+	if entry, _ := m.Get(shared.Key("d")); entry == nil {
+		t.Fatal("d was lost by the move")
+	}
+}
+
+func TestTrivialMoveSkippedForOverlappingInputs(t *testing.T) {
+	m := newTestManager(t, nil)
+	older := addTable(t, m, 0, put("a", 1), put("m", 2))
+	newer := addTable(t, m, 0, put("c", 3), put("z", 4))
+
+	c := &compaction{level: 0, outputLevel: 1, inputs: []*tableFile{older, newer}}
+	if err := m.runCompaction(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.sstables[1]) != 1 {
+		t.Fatalf("level 1 holds %d tables, want the 1 merged table", len(m.sstables[1]))
+	}
+	for _, table := range m.sstables[1] {
+		if table == older || table == newer {
+			t.Fatal("overlapping level 0 tables were moved instead of merged")
+		}
+	}
+}
+
+func TestSubcompactionsSplitLargeJobs(t *testing.T) {
+	opts := DefaultOptions()
+	opts.TargetFileSize = 4096
+	opts.MaxSubcompactions = 4
+	m := newTestManager(t, opts)
+
+	var older, newer []shared.Entry
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		older = append(older, put(key, i+1))
+		if i%3 == 0 {
+			newer = append(newer, del(key, 3000+i))
+		}
+	}
+	addTable(t, m, 3, put("key00500", 0))
+	addTable(t, m, 2, older...)
+	inputs := addTableWithRanges(t, m, 1, deleteRange("key00400", "key01600", 2999), newer...)
+
+	// the job is cut into key ranges the way compactSSTables cuts it
+	var tables []*SSTable
+	var inputSize uint64
+	for _, table := range append([]*tableFile{inputs}, m.sstables[2]...) {
+		handle, err := m.cache.Acquire(table.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handle.Release()
+		tables = append(tables, handle.SSTable)
+		inputSize += table.size()
+	}
+	n := min(opts.MaxSubcompactions, int(inputSize/opts.TargetFileSize))
+	if boundaries := subcompactionBoundaries(tables, n); len(boundaries) == 0 {
+		t.Fatalf("a job of %d bytes runs as a single subcompaction", inputSize)
+	}
+
+	compactInto(t, m, 1, 2, inputs)
+
+	outputs := m.sstables[2]
+	for i := 1; i < len(outputs); i++ {
+		if outputs[i-1].meta.MaxKey.Compare(outputs[i].meta.MinKey) > 0 {
+			t.Fatalf("outputs %d and %d overlap: %q > %q", i-1, i, outputs[i-1].meta.MaxKey, outputs[i].meta.MinKey)
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		entry, err := m.Get(shared.Key(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deleted := i%3 == 0 || i >= 400 && i < 1600
+		if deleted != (entry == nil) {
+			t.Fatalf("%s reads as %+v, deleted %v", key, entry, deleted)
+		}
+	}
+}
+
+func TestTombstoneDenseTableCompacted(t *testing.T) {
+	opts := DefaultOptions()
+	opts.NumLevels = 3
+	opts.TombstoneCompactionRatio = 0.5
+	m := newTestManager(t, opts)
+	addTable(t, m, 2, put("x", 1))
+	addTable(t, m, 1, del("a", 2), del("b", 3), put("c", 4))
+
+	if err := m.fixLevels(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.sstables[1]) != 0 {
+		t.Fatalf("level 1 holds %d tables, want none", len(m.sstables[1]))
+	}
+	if got := tombstones(m, 2); got != 0 {
+		t.Fatalf("level 2 holds %d tombstones, want 0", got)
+	}
+	if entry, _ := m.Get(shared.Key("c")); entry == nil {
+		t.Fatal("c was lost by the compaction")
+	}
+}
+
+func TestTombstoneDenseBottomTableRewritten(t *testing.T) {
+	opts := DefaultOptions()
+	opts.NumLevels = 3
+	opts.TombstoneCompactionRatio = 0.5
+	m := newTestManager(t, opts)
+	dense := addTable(t, m, 2, put("a", 1), del("b", 2), del("c", 3))
+
+	if err := m.fixLevels(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.sstables[2]) != 1 || m.sstables[2][0] == dense {
+		t.Fatal("the dense table in the last level was not rewritten")
+	}
+	if got := tombstones(m, 2); got != 0 {
+		t.Fatalf("level 2 holds %d tombstones, want 0", got)
+	}
+}
+
+func TestAgedTableCompacted(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PeriodicCompactionAge = time.Hour
+	m := newTestManager(t, opts)
+	aged := addTable(t, m, 1, put("a", 1))
+	fresh := addTable(t, m, 1, put("b", 2))
+	aged.meta.Timestamp = time.Now().Add(-2 * time.Hour).UnixNano()
+
+	if err := m.fixLevels(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.sstables[1]) != 1 || m.sstables[1][0] != fresh {
+		t.Fatalf("level 1 holds %d tables, want only the fresh one", len(m.sstables[1]))
+	}
+	if len(m.sstables[2]) != 1 {
+		t.Fatalf("level 2 holds %d tables, want the aged one", len(m.sstables[2]))
+	}
+}
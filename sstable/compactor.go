@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"path/filepath"
+	"sort"
 
 	"github.com/AmrMurad1/Go-Store/shared"
-	"github.com/klauspost/compress/s2"
 )
 
 type SSTableIterator struct {
 	sstable      *SSTable
+	partitionIdx int
+	partition    []shared.IndexRecord
 	blockIdx     int
 	entryIdx     int
 	currentBlock []shared.Entry
@@ -23,34 +26,30 @@ func (st *SSTable) newIterator() (*SSTableIterator, error) {
 	}, nil
 }
 
-func (it *SSTableIterator) seekStart() error {
-	it.blockIdx = 0
-	it.entryIdx = 0
-	it.finished = false
-	return it.loadCurrentBlock()
-}
-
 func (it *SSTableIterator) loadCurrentBlock() error {
-	if it.blockIdx >= len(it.sstable.indexRecords) {
-		it.finished = true
-		return nil
-	}
+	for it.blockIdx >= len(it.partition) {
+		if it.partitionIdx+1 >= it.sstable.indexPartitionCount() {
+			it.finished = true
+			return nil
+		}
 
-	record := it.sstable.indexRecords[it.blockIdx]
-	dataBlockBytes := make([]byte, record.Size)
-	_, err := it.sstable.file.ReadAt(dataBlockBytes, record.Offset)
-	if err != nil {
-		return err
+		var err error
+		it.partitionIdx++
+		it.partition, err = it.sstable.indexPartition(it.partitionIdx)
+		if err != nil {
+			return err
+		}
+		it.blockIdx = 0
 	}
 
-	decompressedBlock, err := s2.Decode(nil, dataBlockBytes)
+	decompressedBlock, err := it.sstable.readDataBlock(it.partition[it.blockIdx], false)
 	if err != nil {
 		return err
 	}
 
 	it.currentBlock = nil
 	blockReader := bytes.NewReader(decompressedBlock)
-	var prevKey shared.Key
+	prevKey := it.sstable.blockPrefixKey(it.partition[it.blockIdx])
 
 	for blockReader.Len() > 0 {
 		var lcp, suffixLen uint16
@@ -69,13 +68,14 @@ func (it *SSTableIterator) loadCurrentBlock() error {
 		value := make([]byte, valLen)
 		io.ReadFull(blockReader, value)
 
-		var tombstone bool
-		binary.Read(blockReader, binary.LittleEndian, &tombstone)
+		kind, version, _ := it.sstable.readKindAndVersion(blockReader)
 
 		entry := shared.Entry{
 			Key:       currentKey,
 			Value:     value,
-			Tombstone: tombstone,
+			Tombstone: kind == entryKindTombstone,
+			Version:   it.sstable.version(version),
+			BlobIndex: kind == entryKindBlobIndex,
 		}
 
 		it.currentBlock = append(it.currentBlock, entry)
@@ -86,7 +86,35 @@ func (it *SSTableIterator) loadCurrentBlock() error {
 	return nil
 }
 
-func (it *SSTableIterator) next() (*shared.Entry, error) {
+// seek positions the iterator at the first entry not less than key.
+func (it *SSTableIterator) seek(key shared.Key) error {
+	it.partitionIdx = 0
+	it.finished = false
+	if it.sstable.isIndexPartitioned() {
+		it.partitionIdx = searchIndex(it.sstable.indexRecords, key)
+		if it.partitionIdx == len(it.sstable.indexRecords) {
+			it.finished = true
+			return nil
+		}
+	}
+
+	var err error
+	it.partition, err = it.sstable.indexPartition(it.partitionIdx)
+	if err != nil {
+		return err
+	}
+	it.blockIdx = searchIndex(it.partition, key)
+	if err := it.loadCurrentBlock(); err != nil || it.finished {
+		return err
+	}
+
+	it.entryIdx = sort.Search(len(it.currentBlock), func(i int) bool {
+		return it.currentBlock[i].Key.Compare(key) >= 0
+	})
+	return nil
+}
+
+func (it *SSTableIterator) Next() (*shared.Entry, error) {
 	if it.finished {
 		return nil, nil
 	}
@@ -111,129 +139,141 @@ func (it *SSTableIterator) next() (*shared.Entry, error) {
 	return nil, nil
 }
 
-func (it *SSTableIterator) close() {
+func (it *SSTableIterator) RangeTombstones() []shared.RangeTombstone {
+	return it.sstable.rangeTombstones
 }
 
-func compact(outputPath string, first *SSTable, second *SSTable, deleteZombie bool, config *SSTableConfig) (*SSTable, error) {
-	firstIterator, err := first.newIterator()
-	if err != nil {
-		return nil, err
-	}
-	defer firstIterator.close()
+func (it *SSTableIterator) Close() error {
+	return nil
+}
 
-	err = firstIterator.seekStart()
+// newIteratorAt returns an iterator positioned at the first entry of st not
+// less than start.
+func (st *SSTable) newIteratorAt(start shared.Key) (*SSTableIterator, error) {
+	it, err := st.newIterator()
 	if err != nil {
 		return nil, err
 	}
-
-	secondIterator, err := second.newIterator()
-	if err != nil {
+	if err := it.seek(start); err != nil {
 		return nil, err
 	}
-	defer secondIterator.close()
+	return it, nil
+}
 
-	err = secondIterator.seekStart()
-	if err != nil {
-		return nil, err
-	}
+// tableIterator keeps the table it reads open until it is closed, along
+// with the blob file it last read a value from.
+type tableIterator struct {
+	*SSTableIterator
+	handle *TableHandle
+	blobs  *blobReader
+}
 
-	writer, err := NewBlockWriter(outputPath, config)
-	if err != nil {
-		return nil, err
+func newTableIterator(it *SSTableIterator, handle *TableHandle) *tableIterator {
+	return &tableIterator{
+		SSTableIterator: it,
+		handle:          handle,
+		blobs:           &blobReader{dir: filepath.Dir(handle.path)},
 	}
+}
 
-	currentFirstEntry, err := firstIterator.next()
-	if err != nil {
-		return nil, err
+// Next returns the next entry with its value read from the blob file when it
+// was separated.
+func (it *tableIterator) Next() (*shared.Entry, error) {
+	entry, err := it.SSTableIterator.Next()
+	if err != nil || entry == nil || !entry.BlobIndex {
+		return entry, err
 	}
+	return it.blobs.resolve(entry)
+}
 
-	currentSecondEntry, err := secondIterator.next()
-	if err != nil {
-		return nil, err
-	}
+func (it *tableIterator) Close() error {
+	it.handle.Release()
+	return it.blobs.close()
+}
 
-	entryCount := 0
+// compactionOutput receives the merged entries of a compaction and writes
+// them to as many tables as needed, starting a new one whenever the current
+// table reaches maxFileSize. A zero maxFileSize keeps everything in one table.
+//
+// Each table gets the part of rangeTombstones between its first key and the
+// first key of the next table, so the outputs never overlap.
+type compactionOutput struct {
+	newPath         func() string
+	config          *SSTableConfig
+	maxFileSize     uint64
+	dictionary      *zstdDictionary
+	rangeTombstones []shared.RangeTombstone
+
+	writer *BlockWriter
+	path   string
+	paths  []string
+	// lower is where the key range of the current table starts, upper where
+	// the range of the last table ends; nil leaves it open.
+	lower shared.Key
+	upper shared.Key
+}
 
-	for currentFirstEntry != nil && currentSecondEntry != nil {
-		if bytes.Equal(currentFirstEntry.Key, currentSecondEntry.Key) {
-			if !(currentSecondEntry.Tombstone && deleteZombie) {
-				err = writer.Add(*currentSecondEntry)
-				if err != nil {
-					return nil, err
-				}
-				entryCount++
-			}
-			currentFirstEntry, err = firstIterator.next()
-			if err != nil {
-				return nil, err
-			}
-			currentSecondEntry, err = secondIterator.next()
-			if err != nil {
-				return nil, err
-			}
-		} else if bytes.Compare(currentFirstEntry.Key, currentSecondEntry.Key) < 0 {
-			if !(currentFirstEntry.Tombstone && deleteZombie) {
-				err = writer.Add(*currentFirstEntry)
-				if err != nil {
-					return nil, err
-				}
-				entryCount++
-			}
-			currentFirstEntry, err = firstIterator.next()
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			if !(currentSecondEntry.Tombstone && deleteZombie) {
-				err = writer.Add(*currentSecondEntry)
-				if err != nil {
-					return nil, err
-				}
-				entryCount++
-			}
-			currentSecondEntry, err = secondIterator.next()
-			if err != nil {
-				return nil, err
-			}
+func (o *compactionOutput) add(entry shared.Entry) error {
+	if o.writer != nil && o.maxFileSize > 0 && o.writer.estimatedSize() >= o.maxFileSize {
+		if err := o.finishTable(entry.Key); err != nil {
+			return err
 		}
 	}
-
-	for currentFirstEntry != nil {
-		if !(currentFirstEntry.Tombstone && deleteZombie) {
-			err = writer.Add(*currentFirstEntry)
-			if err != nil {
-				return nil, err
-			}
-			entryCount++
-		}
-		currentFirstEntry, err = firstIterator.next()
-		if err != nil {
-			return nil, err
+	if o.writer == nil {
+		if err := o.startTable(); err != nil {
+			return err
 		}
 	}
+	return o.writer.Add(entry)
+}
 
-	for currentSecondEntry != nil {
-		if !(currentSecondEntry.Tombstone && deleteZombie) {
-			err = writer.Add(*currentSecondEntry)
-			if err != nil {
-				return nil, err
-			}
-			entryCount++
-		}
-		currentSecondEntry, err = secondIterator.next()
-		if err != nil {
-			return nil, err
+func (o *compactionOutput) startTable() error {
+	o.path = o.newPath()
+	writer, err := NewBlockWriter(o.path, o.config)
+	if err != nil {
+		return err
+	}
+	if o.dictionary != nil {
+		writer.setDictionary(o.dictionary)
+	}
+	o.writer = writer
+	return nil
+}
+
+// finishTable completes the current table, whose key range ends before upper.
+func (o *compactionOutput) finishTable(upper shared.Key) error {
+	for _, tombstone := range o.rangeTombstones {
+		if clipped, ok := clipRangeTombstone(tombstone, o.lower, upper); ok {
+			o.writer.AddRangeTombstone(clipped)
 		}
 	}
 
-	err = writer.Finish()
+	err := o.writer.Finish()
+	o.writer = nil
 	if err != nil {
-		return nil, err
+		return err
 	}
+	o.paths = append(o.paths, o.path)
+	o.lower = upper
+	return nil
+}
 
-	if entryCount == 0 {
-		return nil, nil
+// finish completes the last table. Range tombstones past the last entry
+// still get a table of their own.
+func (o *compactionOutput) finish() error {
+	if o.writer == nil {
+		remaining := false
+		for _, tombstone := range o.rangeTombstones {
+			if _, ok := clipRangeTombstone(tombstone, o.lower, o.upper); ok {
+				remaining = true
+			}
+		}
+		if !remaining {
+			return nil
+		}
+		if err := o.startTable(); err != nil {
+			return err
+		}
 	}
-
-	return Open(outputPath)
+	return o.finishTable(o.upper)
 }
@@ -0,0 +1,115 @@
+package sstable
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionType is stored as the last byte of every data block so each
+// block can be decoded on its own.
+type CompressionType byte
+
+const (
+	NoCompression CompressionType = iota
+	S2Compression
+	SnappyCompression
+	ZstdCompression
+)
+
+// blocks that don't shrink by at least 1/minCompressionRatio are stored raw
+const minCompressionRatio = 8
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func (c CompressionType) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case S2Compression:
+		return "s2"
+	case SnappyCompression:
+		return "snappy"
+	case ZstdCompression:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+// compressBlock encodes raw with the requested codec and appends the type
+// trailer. It falls back to NoCompression when the codec doesn't pay off.
+// A table dictionary, when present, is used for zstd blocks.
+func compressBlock(compression CompressionType, raw []byte, dictionary *zstdDictionary) ([]byte, error) {
+	var compressed []byte
+	switch compression {
+	case NoCompression:
+	case S2Compression:
+		compressed = s2.Encode(nil, raw)
+	case SnappyCompression:
+		compressed = snappy.Encode(nil, raw)
+	case ZstdCompression:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		if dictionary != nil {
+			compressed = dictionary.encoder.EncodeAll(raw, nil)
+		} else {
+			compressed = zstdEncoder.EncodeAll(raw, nil)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression type %d", compression)
+	}
+
+	if compressed == nil || len(compressed) >= len(raw)-len(raw)/minCompressionRatio {
+		compressed = append(make([]byte, 0, len(raw)+1), raw...)
+		compression = NoCompression
+	}
+
+	return append(compressed, byte(compression)), nil
+}
+
+func decompressBlock(block []byte, dictionary *zstdDictionary) ([]byte, error) {
+	if len(block) == 0 {
+		return nil, fmt.Errorf("empty data block")
+	}
+
+	data := block[:len(block)-1]
+	switch compression := CompressionType(block[len(block)-1]); compression {
+	case NoCompression:
+		return data, nil
+	case S2Compression:
+		return s2.Decode(nil, data)
+	case SnappyCompression:
+		return snappy.Decode(nil, data)
+	case ZstdCompression:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		if dictionary != nil {
+			return dictionary.decoder.DecodeAll(data, nil)
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unsupported compression type %d", compression)
+	}
+}
@@ -0,0 +1,91 @@
+package sstable
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func TestCompressBlockRoundTrip(t *testing.T) {
+	raw := bytes.Repeat([]byte("compressible block contents "), 64)
+	for _, compression := range []CompressionType{NoCompression, S2Compression, SnappyCompression, ZstdCompression} {
+		block, err := compressBlock(compression, raw, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if CompressionType(block[len(block)-1]) != compression {
+			t.Fatalf("%s: block trailer says %s", compression, CompressionType(block[len(block)-1]))
+		}
+		decoded, err := decompressBlock(block, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if !bytes.Equal(decoded, raw) {
+			t.Fatalf("%s: block did not round-trip", compression)
+		}
+	}
+}
+
+func TestIncompressibleBlockStoredRaw(t *testing.T) {
+	raw := make([]byte, 4096)
+	rand.Read(raw)
+
+	for _, compression := range []CompressionType{S2Compression, SnappyCompression, ZstdCompression} {
+		block, err := compressBlock(compression, raw, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if CompressionType(block[len(block)-1]) != NoCompression {
+			t.Fatalf("%s: incompressible block stored as %s", compression, CompressionType(block[len(block)-1]))
+		}
+		decoded, err := decompressBlock(block, nil)
+		if err != nil || !bytes.Equal(decoded, raw) {
+			t.Fatalf("%s: raw fallback did not round-trip: %v", compression, err)
+		}
+	}
+}
+
+func TestDecompressRejectsUnknownCodec(t *testing.T) {
+	if _, err := decompressBlock([]byte{1, 2, 3, 42}, nil); err == nil {
+		t.Fatal("block with an unknown codec was decoded")
+	}
+	if _, err := compressBlock(CompressionType(42), []byte("x"), nil); err == nil {
+		t.Fatal("block was encoded with an unknown codec")
+	}
+}
+
+func TestCompressionPerLevel(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CompressionPerLevel = []CompressionType{NoCompression, SnappyCompression, ZstdCompression}
+	m := newTestManager(t, opts)
+
+	for level, want := range []CompressionType{NoCompression, SnappyCompression, ZstdCompression, ZstdCompression} {
+		if got := m.LevelConfig(level).Compression; got != want {
+			t.Fatalf("level %d compresses with %s, want %s", level, got, want)
+		}
+	}
+
+	var entries []shared.Entry
+	for i := 0; i < 200; i++ {
+		entries = append(entries, shared.Entry{
+			Key:     shared.Key(fmt.Sprintf("key%04d", i)),
+			Value:   bytes.Repeat([]byte("v"), 100),
+			Version: i + 1,
+		})
+	}
+	table := addTable(t, m, 2, entries...)
+	if CompressionType(table.meta.Compression) != ZstdCompression {
+		t.Fatalf("level 2 table records %s", CompressionType(table.meta.Compression))
+	}
+	if table.meta.DataSize >= table.meta.RawDataSize {
+		t.Fatalf("zstd table is %d bytes on disk for %d raw", table.meta.DataSize, table.meta.RawDataSize)
+	}
+
+	entry, err := m.Get(shared.Key("key0100"))
+	if err != nil || entry == nil || !bytes.Equal(entry.Value, entries[100].Value) {
+		t.Fatalf("key0100 reads as %+v, %v", entry, err)
+	}
+}
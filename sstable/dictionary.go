@@ -0,0 +1,99 @@
+package sstable
+
+import (
+	"github.com/AmrMurad1/Go-Store/shared"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxDictSampleBlocks bounds how many data blocks are read from the inputs
+// of a compaction to train its dictionary.
+const maxDictSampleBlocks = 128
+
+// zstdDictionary is a trained dictionary shared by every data block of one
+// SSTable. It is stored in the table's dictionary block.
+type zstdDictionary struct {
+	raw     []byte
+	gain    float64
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func loadDictionary(raw []byte) (*zstdDictionary, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(raw), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(raw), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		encoder.Close()
+		return nil, err
+	}
+
+	return &zstdDictionary{
+		raw:     raw,
+		encoder: encoder,
+		decoder: decoder,
+	}, nil
+}
+
+// trainDictionary samples data blocks evenly from the given tables and builds
+// a zstd dictionary of at most maxSize bytes from them. The gain is the size
+// of the samples compressed without the dictionary divided by their size with it.
+func trainDictionary(maxSize int, tables ...*SSTable) (*zstdDictionary, error) {
+	tableRecords := make([][]shared.IndexRecord, len(tables))
+	totalBlocks := 0
+	for i, table := range tables {
+		records, err := table.dataBlockRecords()
+		if err != nil {
+			return nil, err
+		}
+		tableRecords[i] = records
+		totalBlocks += len(records)
+	}
+	step := max(1, totalBlocks/maxDictSampleBlocks)
+
+	var samples [][]byte
+	for i, table := range tables {
+		for j := 0; j < len(tableRecords[i]); j += step {
+			block, err := table.readDataBlock(tableRecords[i][j], false)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, block)
+		}
+	}
+
+	raw, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxSize,
+		HashBytes:   6,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := loadDictionary(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := initZstd(); err != nil {
+		d.close()
+		return nil, err
+	}
+	plainSize, dictSize := 0, 0
+	for _, sample := range samples {
+		plainSize += len(zstdEncoder.EncodeAll(sample, nil))
+		dictSize += len(d.encoder.EncodeAll(sample, nil))
+	}
+	if dictSize > 0 {
+		d.gain = float64(plainSize) / float64(dictSize)
+	}
+
+	return d, nil
+}
+
+func (d *zstdDictionary) close() {
+	d.encoder.Close()
+	d.decoder.Close()
+}
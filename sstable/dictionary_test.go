@@ -0,0 +1,92 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// dictionaryEntries returns small records that share most of their bytes,
+// the workload dictionaries are meant for.
+func dictionaryEntries(first, n int) []shared.Entry {
+	entries := make([]shared.Entry, n)
+	for i := range entries {
+		id := first + i
+		entries[i] = shared.Entry{
+			Key:     shared.Key(fmt.Sprintf("user:%06d", id)),
+			Value:   []byte(fmt.Sprintf(`{"id":%d,"status":"active","plan":"standard","region":"eu-west"}`, id)),
+			Version: id + 1,
+		}
+	}
+	return entries
+}
+
+func TestDictionaryTrainedForZstdCompaction(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CompressionPerLevel = []CompressionType{ZstdCompression}
+	opts.ZstdDictionarySize = 4096
+	m := newTestManager(t, opts)
+
+	var inputs []*tableFile
+	for i := 0; i < 4; i++ {
+		inputs = append(inputs, addTable(t, m, 0, dictionaryEntries(i*500, 500)...))
+	}
+	if inputs[0].meta.DictionarySize != 0 {
+		t.Fatal("a flushed table was written with a dictionary")
+	}
+
+	compactInto(t, m, 0, 1, inputs...)
+
+	for _, table := range m.sstables[1] {
+		if table.meta.DictionarySize == 0 {
+			t.Fatalf("compaction output has a %d byte dictionary", table.meta.DictionarySize)
+		}
+		if table.meta.DictionaryGain <= 0 {
+			t.Fatalf("compaction output records dictionary gain %v", table.meta.DictionaryGain)
+		}
+	}
+	for _, id := range []int{0, 777, 1999} {
+		want := dictionaryEntries(id, 1)[0]
+		entry, err := m.Get(want.Key)
+		if err != nil || entry == nil || !bytes.Equal(entry.Value, want.Value) {
+			t.Fatalf("%s reads as %+v, %v", want.Key, entry, err)
+		}
+	}
+}
+
+func TestDictionaryBlocksNeedTheirDictionary(t *testing.T) {
+	m := newTestManager(t, nil)
+	table := addTable(t, m, 0, dictionaryEntries(0, 500)...)
+	handle, err := m.cache.Acquire(table.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Release()
+
+	d, err := trainDictionary(2048, handle.SSTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+
+	raw := []byte(`{"id":123456,"status":"active","plan":"standard","region":"eu-west"}`)
+	block, err := compressBlock(ZstdCompression, bytes.Repeat(raw, 4), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decompressBlock(block, d)
+	if err != nil || !bytes.Equal(decoded, bytes.Repeat(raw, 4)) {
+		t.Fatalf("block did not round-trip through the dictionary: %v", err)
+	}
+
+	reloaded, err := loadDictionary(d.raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.close()
+	if decoded, err := decompressBlock(block, reloaded); err != nil || !bytes.Equal(decoded, bytes.Repeat(raw, 4)) {
+		t.Fatalf("block did not decode with the dictionary loaded from its bytes: %v", err)
+	}
+}
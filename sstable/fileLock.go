@@ -0,0 +1,18 @@
+package sstable
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFileName is held exclusively by the manager writing to a directory.
+const lockFileName = "LOCK"
+
+// ErrLocked is returned when another manager already writes to the
+// directory.
+var ErrLocked = errors.New("database directory is locked by another process")
+
+type fileLock struct {
+	file *os.File
+	path string
+}
@@ -0,0 +1,43 @@
+//go:build !unix
+
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Without flock the lock only keeps out other managers of this process.
+var (
+	lockedMu sync.Mutex
+	locked   = make(map[string]bool)
+)
+
+func lockFile(path string) (*fileLock, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	lockedMu.Lock()
+	defer lockedMu.Unlock()
+	if locked[abs] {
+		return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	locked[abs] = true
+	return &fileLock{file: file, path: abs}, nil
+}
+
+func (l *fileLock) unlock() error {
+	lockedMu.Lock()
+	delete(locked, l.path)
+	lockedMu.Unlock()
+	return l.file.Close()
+}
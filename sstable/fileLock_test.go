@@ -0,0 +1,29 @@
+package sstable
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSecondWriterGetsErrLocked(t *testing.T) {
+	m := newTestManager(t, nil)
+
+	if _, err := NewSSManager(m.dir, DefaultOptions()); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second manager opened with %v, want ErrLocked", err)
+	}
+
+	reader, err := NewReadOnlySSManager(m.dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("read-only manager failed to open next to the writer: %v", err)
+	}
+	reader.Close()
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewSSManager(m.dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("manager failed to open after the first one closed: %v", err)
+	}
+	reopened.Close()
+}
@@ -0,0 +1,65 @@
+package sstable
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFileNumber(t *testing.T) {
+	cases := []struct {
+		name   string
+		number uint64
+		ok     bool
+	}{
+		{TableFileName(12), 12, true},
+		{BlobFileName(7), 7, true},
+		{"000031.log", 31, true},
+		{manifestFileName(5), 5, true},
+		{"CURRENT", 0, false},
+		{"temp.sst", 0, false},
+		{".sst", 0, false},
+	}
+	for _, c := range cases {
+		number, ok := parseFileNumber(c.name)
+		if number != c.number || ok != c.ok {
+			t.Errorf("parseFileNumber(%q) = %d, %v; want %d, %v", c.name, number, ok, c.number, c.ok)
+		}
+	}
+}
+
+func TestFileNumbersNotReusedAfterReopen(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 0, put("a", 1))
+	last := m.NewFileNumber()
+
+	reopened := reopen(t, m)
+	if next := reopened.NewFileNumber(); next <= last {
+		t.Fatalf("file number %d handed out again after reopen, last was %d", next, last)
+	}
+}
+
+func TestFileNumbersSkipUnrecordedFiles(t *testing.T) {
+	m := newTestManager(t, nil)
+	dir := m.dir
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a table written just before a crash, never recorded in the manifest
+	orphan := filepath.Join(dir, TableFileName(50))
+	writeTestTable(t, orphan, defaultTableConfig(), put("a", 1))
+
+	reopened, err := NewSSManager(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if next := reopened.NewFileNumber(); next <= 50 {
+		t.Fatalf("file number %d handed out below the unrecorded table 50", next)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("unrecorded table was not removed: %v", err)
+	}
+}
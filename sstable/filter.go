@@ -1,15 +1,19 @@
 package sstable
 
 import (
-	"hash"
+	"encoding/binary"
+	"errors"
 	"math"
 
 	"github.com/spaolacci/murmur3"
 )
 
+// Filter is a bloom filter. It holds no hashing state, so a decoded filter
+// may be shared by concurrent readers.
 type Filter struct {
-	bitset  []bool
-	hashFns []hash.Hash32
+	bitset []bool
+	// hashes is the number of seeded murmur3 hashes set per key.
+	hashes int
 }
 
 func New(n int, p float64) *Filter {
@@ -24,58 +28,67 @@ func New(n int, p float64) *Filter {
 		return nil
 	}
 
-	hashFns := make([]hash.Hash32, k)
-	for i := 0; i < k; i++ {
-		hashFns[i] = murmur3.New32WithSeed(uint32(i))
-	}
-
 	return &Filter{
-		bitset:  make([]bool, m),
-		hashFns: hashFns,
+		bitset: make([]bool, m),
+		hashes: k,
 	}
 }
 
 // Add adds a key to the bloom filter
 func (f *Filter) Add(key string) {
-	for _, fn := range f.hashFns {
-		_, _ = fn.Write([]byte(key))
-		index := int(fn.Sum32()) % len(f.bitset)
-		f.bitset[index] = true
-		fn.Reset()
+	for i := 0; i < f.hashes; i++ {
+		f.bitset[f.index(key, i)] = true
 	}
 }
 
 func (f *Filter) Contains(key string) bool {
-	for _, fn := range f.hashFns {
-		_, _ = fn.Write([]byte(key))
-		index := int(fn.Sum32()) % len(f.bitset)
-		fn.Reset()
-		if !f.bitset[index] {
+	for i := 0; i < f.hashes; i++ {
+		if !f.bitset[f.index(key, i)] {
 			return false
 		}
 	}
 	return true
 }
 
-// Encode serializes the Bloom Filter's bitset to a byte slice.
+// index returns the bit set for key by the hash seeded with seed. Every call
+// hashes with its own digest; murmur3.Sum32WithSeed would avoid it but trips
+// the checkptr checks of race builds.
+func (f *Filter) index(key string, seed int) int {
+	h := murmur3.New32WithSeed(uint32(seed))
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(f.bitset)))
+}
+
+// Encode serializes the Bloom Filter's bitset to a byte slice, followed by
+// the number of bits (uint32) and hash functions (uint8) needed to decode it.
 func (f *Filter) Encode() []byte {
-	buf := make([]byte, (len(f.bitset)+7)/8)
+	buf := make([]byte, (len(f.bitset)+7)/8, (len(f.bitset)+7)/8+5)
 	for i, b := range f.bitset {
 		if b {
 			buf[i/8] |= 1 << (i % 8)
 		}
 	}
-	return buf
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(f.bitset)))
+	return append(buf, uint8(f.hashes))
 }
 
 // Decode deserializes a byte slice into a new Bloom Filter.
 func Decode(data []byte) (*Filter, error) {
-	// This is a simplified decode. A real implementation would need to
-	// store m and k in the serialized data. For now, we assume the filter
-	// is created with the correct parameters before decoding.
-	// This is a placeholder and needs a robust implementation.
+	if len(data) < 5 {
+		return nil, errors.New("invalid bloom filter: too short")
+	}
+
+	trailer := data[len(data)-5:]
+	m := int(binary.LittleEndian.Uint32(trailer))
+	k := int(trailer[4])
+	data = data[:len(data)-5]
+	if m == 0 || k == 0 || (m+7)/8 != len(data) {
+		return nil, errors.New("invalid bloom filter: size mismatch")
+	}
+
 	f := &Filter{
-		bitset: make([]bool, len(data)*8),
+		bitset: make([]bool, m),
+		hashes: k,
 	}
 	for i := 0; i < len(f.bitset); i++ {
 		if (data[i/8] & (1 << (i % 8))) != 0 {
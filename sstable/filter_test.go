@@ -0,0 +1,38 @@
+package sstable
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestDecodedFilterSharedByConcurrentReaders(t *testing.T) {
+	built := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		built.Add(fmt.Sprintf("key%04d", i))
+	}
+	filter, err := Decode(built.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	missed := make(chan string, 8)
+	for reader := 0; reader < 8; reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if key := fmt.Sprintf("key%04d", i); !filter.Contains(key) {
+					missed <- key
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(missed)
+	for key := range missed {
+		t.Fatalf("filter reported %s missing while read concurrently", key)
+	}
+}
@@ -1,26 +1,29 @@
 package sstable
 
-import "github.com/AmrMurad1/Go-Store/shared"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
 
-const (
-	MagicNumber uint64 = 0xDEADBEEFCAFE
-	FooterSize  uint64 = 44
+	"github.com/AmrMurad1/Go-Store/shared"
 )
 
-type IndexRecord struct {
-	LastKey shared.Key
-	Offset  int64
-	Size    int32
-}
+// ErrUnsupportedFormat is returned for tables whose layout this version
+// cannot read.
+var ErrUnsupportedFormat = errors.New("unsupported sstable format")
 
-type MetaBlock struct {
-	EntryCount uint64
-	MinKey     shared.Key
-	MaxKey     shared.Key
-	Timestamp  int64
-}
+// legacyFormatVersion is the format version of tables written before the
+// layout was versioned. Their data blocks are s2 compressed without a
+// compression byte, entries end with a tombstone bool instead of a kind and
+// version, the meta block holds only the entry count, key range and
+// timestamp, and the filter is a bare bitset.
+const legacyFormatVersion uint32 = 0
 
-type Footer struct {
+// legacyFooter is the footer of legacy tables.
+type legacyFooter struct {
 	MetaBlockOffset  int64
 	MetaBlockSize    uint32
 	IndexBlockOffset int64
@@ -29,3 +32,139 @@ type Footer struct {
 	FilterSize       uint32
 	Magic            uint64
 }
+
+// readFooter reads and checks the footer of the table in file, which is size
+// bytes long.
+func readFooter(file *os.File, size int64) (shared.Footer, error) {
+	var footer shared.Footer
+
+	magicBytes := make([]byte, 8)
+	if size < 8 {
+		return footer, errors.New("invalid sstable file: too short")
+	}
+	if _, err := file.ReadAt(magicBytes, size-8); err != nil {
+		return footer, err
+	}
+	switch binary.LittleEndian.Uint64(magicBytes) {
+	case shared.MagicNumber:
+	case shared.LegacyMagicNumber:
+		return readLegacyFooter(file, size)
+	default:
+		return footer, errors.New("invalid sstable file: magic number mismatch")
+	}
+
+	footerBytes := make([]byte, shared.FooterSize)
+	if size < int64(shared.FooterSize) {
+		return footer, errors.New("invalid sstable file: too short")
+	}
+	if _, err := file.ReadAt(footerBytes, size-int64(shared.FooterSize)); err != nil {
+		return footer, err
+	}
+	if err := binary.Read(bytes.NewReader(footerBytes), binary.LittleEndian, &footer); err != nil {
+		return footer, err
+	}
+	if footer.FormatVersion != shared.FormatVersion {
+		return footer, fmt.Errorf("%w: version %d, this build reads version %d",
+			ErrUnsupportedFormat, footer.FormatVersion, shared.FormatVersion)
+	}
+	return footer, nil
+}
+
+func readLegacyFooter(file *os.File, size int64) (shared.Footer, error) {
+	if size < int64(shared.LegacyFooterSize) {
+		return shared.Footer{}, errors.New("invalid sstable file: too short")
+	}
+	footerBytes := make([]byte, shared.LegacyFooterSize)
+	if _, err := file.ReadAt(footerBytes, size-int64(shared.LegacyFooterSize)); err != nil {
+		return shared.Footer{}, err
+	}
+	var legacy legacyFooter
+	if err := binary.Read(bytes.NewReader(footerBytes), binary.LittleEndian, &legacy); err != nil {
+		return shared.Footer{}, err
+	}
+	return shared.Footer{
+		MetaBlockOffset:  legacy.MetaBlockOffset,
+		MetaBlockSize:    legacy.MetaBlockSize,
+		IndexBlockOffset: legacy.IndexBlockOffset,
+		IndexBlockSize:   legacy.IndexBlockSize,
+		FilterOffset:     legacy.FilterOffset,
+		FilterSize:       legacy.FilterSize,
+		FormatVersion:    legacyFormatVersion,
+		Magic:            legacy.Magic,
+	}, nil
+}
+
+// decodeLegacyMetaBlock decodes the meta block of a legacy table.
+func decodeLegacyMetaBlock(data []byte) (shared.MetaBlock, error) {
+	var meta shared.MetaBlock
+	metaReader := bytes.NewReader(data)
+
+	if err := binary.Read(metaReader, binary.LittleEndian, &meta.EntryCount); err != nil {
+		return meta, err
+	}
+	minKey, err := readBytes(metaReader)
+	if err != nil {
+		return meta, err
+	}
+	meta.MinKey = shared.Key(minKey)
+	maxKey, err := readBytes(metaReader)
+	if err != nil {
+		return meta, err
+	}
+	meta.MaxKey = shared.Key(maxKey)
+	if err := binary.Read(metaReader, binary.LittleEndian, &meta.Timestamp); err != nil {
+		return meta, err
+	}
+	return meta, nil
+}
+
+func (s *SSTable) isLegacy() bool {
+	return s.footer.FormatVersion == legacyFormatVersion
+}
+
+// readKindAndVersion reads what follows the value of an entry. Legacy
+// entries end with a tombstone bool, which reads as entryKindValue or
+// entryKindTombstone, and carry no version.
+func (s *SSTable) readKindAndVersion(blockReader *bytes.Reader) (byte, uint64, error) {
+	kind, err := blockReader.ReadByte()
+	if err != nil || s.isLegacy() {
+		return kind, 0, err
+	}
+	var version uint64
+	err = binary.Read(blockReader, binary.LittleEndian, &version)
+	return kind, version, err
+}
+
+// blockPrefixKey returns the key the first entry of the block at record
+// shares its prefix with. Legacy tables carried prefixes over from the last
+// key of the previous block.
+func (s *SSTable) blockPrefixKey(record shared.IndexRecord) shared.Key {
+	if !s.isLegacy() {
+		return nil
+	}
+	i := sort.Search(len(s.indexRecords), func(i int) bool {
+		return s.indexRecords[i].Offset >= record.Offset
+	})
+	if i == 0 {
+		return nil
+	}
+	return s.indexRecords[i-1].LastKey
+}
+
+// checkTableFormat fails unless the table at path can be read.
+func checkTableFormat(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := readFooter(file, stat.Size()); err != nil {
+		return fmt.Errorf("table %s: %w", path, err)
+	}
+	return nil
+}
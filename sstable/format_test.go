@@ -0,0 +1,215 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+	"github.com/klauspost/compress/s2"
+)
+
+// stampFormatVersion overwrites the format version in the footer of the
+// table at path.
+func stampFormatVersion(t *testing.T, path string, version uint32) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := binary.LittleEndian.AppendUint32(nil, version)
+	if _, err := file.WriteAt(buf, stat.Size()-12); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeLegacyTable writes entries, given in key order, the way tables were
+// written before the format was versioned: blockSize bytes of entries per s2
+// block, key prefixes shared across blocks, a tombstone bool per entry and a
+// bare filter bitset.
+func writeLegacyTable(t *testing.T, path string, blockSize int, entries ...shared.Entry) {
+	t.Helper()
+	var out, block bytes.Buffer
+	var index []shared.IndexRecord
+	var prevKey shared.Key
+	flush := func() {
+		if block.Len() == 0 {
+			return
+		}
+		compressed := s2.Encode(nil, block.Bytes())
+		index = append(index, shared.IndexRecord{LastKey: prevKey, Offset: int64(out.Len()), Size: int32(len(compressed))})
+		out.Write(compressed)
+		block.Reset()
+	}
+
+	filter := New(len(entries), 0.01)
+	for _, entry := range entries {
+		filter.Add(string(entry.Key))
+		lcp := sharedPrefixLen(prevKey, entry.Key)
+		binary.Write(&block, binary.LittleEndian, uint16(lcp))
+		binary.Write(&block, binary.LittleEndian, uint16(len(entry.Key)-lcp))
+		block.Write(entry.Key[lcp:])
+		binary.Write(&block, binary.LittleEndian, uint32(len(entry.Value)))
+		block.Write(entry.Value)
+		binary.Write(&block, binary.LittleEndian, entry.Tombstone)
+		prevKey = entry.Key
+		if block.Len() >= blockSize {
+			flush()
+		}
+	}
+	flush()
+
+	var footer legacyFooter
+	footer.FilterOffset = int64(out.Len())
+	filterBytes := filter.Encode()
+	filterBytes = filterBytes[:len(filterBytes)-5]
+	out.Write(filterBytes)
+	footer.FilterSize = uint32(len(filterBytes))
+
+	footer.MetaBlockOffset = int64(out.Len())
+	binary.Write(&out, binary.LittleEndian, uint64(len(entries)))
+	for _, key := range []shared.Key{entries[0].Key, entries[len(entries)-1].Key} {
+		binary.Write(&out, binary.LittleEndian, uint32(len(key)))
+		out.Write(key)
+	}
+	binary.Write(&out, binary.LittleEndian, int64(1))
+	footer.MetaBlockSize = uint32(int64(out.Len()) - footer.MetaBlockOffset)
+
+	footer.IndexBlockOffset = int64(out.Len())
+	for _, record := range index {
+		binary.Write(&out, binary.LittleEndian, uint32(len(record.LastKey)))
+		out.Write(record.LastKey)
+		binary.Write(&out, binary.LittleEndian, record.Offset)
+		binary.Write(&out, binary.LittleEndian, record.Size)
+	}
+	footer.IndexBlockSize = uint32(int64(out.Len()) - footer.IndexBlockOffset)
+
+	footer.Magic = shared.LegacyMagicNumber
+	binary.Write(&out, binary.LittleEndian, &footer)
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// legacyEntries returns count entries sharing long key prefixes, every
+// tenth one a tombstone, without versions.
+func legacyEntries(count int) []shared.Entry {
+	entries := make([]shared.Entry, count)
+	for i := range entries {
+		entries[i] = put(fmt.Sprintf("legacy-key-%05d", i), 0)
+		if i%10 == 0 {
+			entries[i] = del(string(entries[i].Key), 0)
+		}
+	}
+	return entries
+}
+
+func TestOpenReadsLegacyTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "0.0.sst")
+	entries := legacyEntries(200)
+	writeLegacyTable(t, path, 256, entries...)
+
+	table, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	if len(table.indexRecords) < 2 {
+		t.Fatalf("legacy table has %d blocks, want several", len(table.indexRecords))
+	}
+
+	for _, want := range entries {
+		got, err := table.Get(want.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.Tombstone != want.Tombstone || string(got.Value) != string(want.Value) {
+			t.Fatalf("Get(%s) = %+v, want %+v", want.Key, got, want)
+		}
+	}
+
+	it, err := table.newIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := it.seek(nil); err != nil {
+		t.Fatal(err)
+	}
+	assertEntries(t, drain(t, it), entries...)
+}
+
+func TestLegacyLayoutIsRecovered(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyTable(t, filepath.Join(dir, "0.0.sst"), 256, legacyEntries(200)...)
+
+	// the second open finds the tables through the manifest the first wrote
+	for i := 0; i < 2; i++ {
+		m, err := NewSSManager(dir, DefaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := m.Get(shared.Key("legacy-key-00123"))
+		if err != nil || entry == nil || string(entry.Value) != "legacy-key-00123-value" {
+			t.Fatalf("Get from a legacy table returned %+v, %v", entry, err)
+		}
+		if entry, err := m.Get(shared.Key("legacy-key-00120")); err != nil || entry != nil {
+			t.Fatalf("Get of a legacy tombstone returned %+v, %v", entry, err)
+		}
+		if err := m.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenRejectsUnknownFormatVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), TableFileName(1))
+	writeTestTable(t, path, defaultTableConfig(), put("a", 1))
+	if _, err := Open(path); err != nil {
+		t.Fatalf("current table failed to open: %v", err)
+	}
+
+	stampFormatVersion(t, path, shared.FormatVersion+1)
+	if _, err := Open(path); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("table of a newer format opened with %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestRecoveryFailsOnUnreadableTable(t *testing.T) {
+	m := newTestManager(t, nil)
+	table := addTable(t, m, 1, put("a", 1))
+	dir := m.dir
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stampFormatVersion(t, table.path, shared.FormatVersion+1)
+	if _, err := NewSSManager(dir, DefaultOptions()); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("manager opened over an unreadable table with %v", err)
+	}
+	if _, err := os.Stat(table.path); err != nil {
+		t.Fatalf("unreadable table was removed: %v", err)
+	}
+}
+
+func TestLegacyLayoutWithUnreadableTableIsKept(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "0.0.sst")
+	writeTestTable(t, path, defaultTableConfig(), put("a", 1))
+	stampFormatVersion(t, path, shared.FormatVersion+1)
+
+	if _, err := NewSSManager(dir, DefaultOptions()); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("manager opened over an unreadable legacy table with %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("unreadable legacy table was removed: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package sstable
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// legacyFlushFileName is where older versions wrote a flush before moving it
+// into level 0; one is left behind whenever such a flush was aborted.
+const legacyFlushFileName = "temp.sst"
+
+// deleteObsoleteFiles removes files the current version no longer needs:
+// tables that were compacted away or never installed, compaction
+// intermediates, aborted flushes, WALs that were already flushed, old
+// manifests and blob files no table refers to. Tables still held by a reader
+// are kept until a later pass, and so are the blob files they refer to.
+func (m *SSManager) deleteObsoleteFiles() {
+	files, err := os.ReadDir(m.dir)
+	if err != nil {
+		log.Printf("Warning: obsolete file cleanup failed: %v", err)
+		return
+	}
+
+	live := make(map[string]bool)
+	referencedBlobs := make(map[uint64]bool)
+	for number := range m.liveBlobBytes() {
+		referencedBlobs[number] = true
+	}
+	for _, level := range m.sstables {
+		for _, table := range level {
+			live[filepath.Base(table.path)] = true
+		}
+	}
+	for _, file := range files {
+		path := filepath.Join(m.dir, file.Name())
+		if !strings.HasSuffix(file.Name(), ".sst") || live[file.Name()] || !m.cache.InUse(path) {
+			continue
+		}
+		if handle, err := m.cache.Acquire(path); err == nil {
+			for number := range handle.meta.BlobReferences {
+				referencedBlobs[number] = true
+			}
+			handle.Release()
+		}
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || live[name] || !m.isObsolete(name, referencedBlobs) {
+			continue
+		}
+
+		path := filepath.Join(m.dir, name)
+		if m.cache.InUse(path) {
+			continue
+		}
+
+		m.cache.Evict(path)
+		if err := os.Remove(path); err != nil {
+			log.Printf("Warning: failed to delete obsolete file %s: %v", name, err)
+			continue
+		}
+		log.Printf("Deleted obsolete file %s", name)
+	}
+}
+
+func (m *SSManager) isObsolete(name string, referencedBlobs map[uint64]bool) bool {
+	number, numbered := parseFileNumber(name)
+
+	switch {
+	case name == legacyFlushFileName:
+		return true
+	case strings.Contains(name, ".tmp"):
+		return true
+	case strings.HasSuffix(name, ".sst"):
+		return numbered && !m.pendingOutputs[number]
+	case strings.HasSuffix(name, ".blob"):
+		return numbered && !m.pendingOutputs[number] && !referencedBlobs[number]
+	case strings.HasSuffix(name, ".log"):
+		return numbered && number < m.logNumber
+	case strings.HasPrefix(name, "MANIFEST-"):
+		return numbered && m.manifest != nil && number != m.manifest.number
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func writeFlush(t *testing.T, m *SSManager, entries ...shared.Entry) string {
+	t.Helper()
+	path := filepath.Join(m.dir, m.NewTableFileName())
+	writeTestTable(t, path, m.LevelConfig(0), entries...)
+	return path
+}
+
+func TestObsoleteFilesDeletedOnRecovery(t *testing.T) {
+	m := newTestManager(t, nil)
+	live := addTable(t, m, 1, put("a", 1))
+	logNumber := m.NewFileNumber()
+	if err := m.AddSSTable(writeFlush(t, m, put("b", 2)), logNumber); err != nil {
+		t.Fatal(err)
+	}
+	dir := m.dir
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	leftovers := []string{
+		legacyFlushFileName,
+		TableFileName(90) + ".tmp",
+		TableFileName(91),
+		fmt.Sprintf("%06d.log", logNumber-1),
+		manifestFileName(92),
+	}
+	for _, name := range leftovers {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("leftover"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unrelated := filepath.Join(dir, "notes.txt")
+	os.WriteFile(unrelated, nil, 0644)
+
+	reopened, err := NewSSManager(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	for _, name := range leftovers {
+		if exists(filepath.Join(dir, name)) {
+			t.Errorf("obsolete file %s was kept", name)
+		}
+	}
+	if !exists(live.path) {
+		t.Error("live table was deleted")
+	}
+	if !exists(unrelated) {
+		t.Error("a file the engine does not own was deleted")
+	}
+	if !exists(currentManifest(t, dir)) {
+		t.Error("the live manifest was deleted")
+	}
+}
+
+func TestCompactedTableKeptWhileRead(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 2, put("a", 1))
+	input := addTable(t, m, 1, put("a", 2))
+
+	handle, err := m.cache.Acquire(input.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compactInto(t, m, 1, 2, input)
+	if !exists(input.path) {
+		t.Fatal("compacted table deleted while a reader holds it")
+	}
+
+	handle.Release()
+	m.mu.Lock()
+	m.deleteObsoleteFiles()
+	m.mu.Unlock()
+	if exists(input.path) {
+		t.Fatal("compacted table kept after its last reader released it")
+	}
+}
@@ -0,0 +1,181 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// A partitioned index splits the index records of a table into partitions of
+// roughly MetadataBlockSize bytes. The index block in the footer then holds a
+// small top-level index with one record per partition, whose LastKey is the
+// last key of the partition and whose Offset/Size locate the partition.
+// Partitioned filters use the same layout with one bloom filter per partition.
+
+func encodeIndexRecords(records []shared.IndexRecord) []byte {
+	indexBuf := new(bytes.Buffer)
+	for _, record := range records {
+		binary.Write(indexBuf, binary.LittleEndian, uint32(len(record.LastKey)))
+		indexBuf.Write([]byte(record.LastKey))
+		binary.Write(indexBuf, binary.LittleEndian, record.Offset)
+		binary.Write(indexBuf, binary.LittleEndian, record.Size)
+	}
+	return indexBuf.Bytes()
+}
+
+func decodeIndexRecords(data []byte) ([]shared.IndexRecord, error) {
+	var records []shared.IndexRecord
+	indexReader := bytes.NewReader(data)
+	for indexReader.Len() > 0 {
+		var keyLen uint32
+		if err := binary.Read(indexReader, binary.LittleEndian, &keyLen); err != nil {
+			return nil, err
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(indexReader, key); err != nil {
+			return nil, err
+		}
+		var record shared.IndexRecord
+		record.LastKey = shared.Key(key)
+		if err := binary.Read(indexReader, binary.LittleEndian, &record.Offset); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(indexReader, binary.LittleEndian, &record.Size); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func indexRecordsSize(records []shared.IndexRecord) int {
+	size := 0
+	for _, record := range records {
+		size += 4 + len(record.LastKey) + 8 + 4
+	}
+	return size
+}
+
+// searchIndex returns the position of the first record whose LastKey is
+// not less than key, or len(records) when there is none.
+func searchIndex(records []shared.IndexRecord, key shared.Key) int {
+	return sort.Search(len(records), func(i int) bool {
+		return records[i].LastKey.Compare(key) >= 0
+	})
+}
+
+func (s *SSTable) isIndexPartitioned() bool {
+	return s.meta.IndexPartitions > 0
+}
+
+func (s *SSTable) isFilterPartitioned() bool {
+	return s.meta.FilterPartitions > 0
+}
+
+func (s *SSTable) indexPartitionCount() int {
+	if s.isIndexPartitioned() {
+		return len(s.indexRecords)
+	}
+	return 1
+}
+
+// indexPartition returns the data block records of partition i, loading it
+// through the block cache when the index is partitioned.
+func (s *SSTable) indexPartition(i int) ([]shared.IndexRecord, error) {
+	if !s.isIndexPartitioned() {
+		return s.indexRecords, nil
+	}
+
+	record := s.indexRecords[i]
+	key := blockKey{path: s.path, offset: record.Offset}
+	if cached, ok := s.blockCache.get(key); ok {
+		return cached.([]shared.IndexRecord), nil
+	}
+
+	partitionBytes := make([]byte, record.Size)
+	if err := s.readAt(partitionBytes, record.Offset); err != nil {
+		return nil, err
+	}
+	records, err := decodeIndexRecords(partitionBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.blockCache.put(key, records, len(partitionBytes))
+	return records, nil
+}
+
+// dataBlockRecords returns the index records of every data block.
+func (s *SSTable) dataBlockRecords() ([]shared.IndexRecord, error) {
+	if !s.isIndexPartitioned() {
+		return s.indexRecords, nil
+	}
+
+	var records []shared.IndexRecord
+	for i := range s.indexRecords {
+		partition, err := s.indexPartition(i)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, partition...)
+	}
+	return records, nil
+}
+
+// findDataBlock returns the record of the only data block that can hold key.
+func (s *SSTable) findDataBlock(key shared.Key) (shared.IndexRecord, bool, error) {
+	records := s.indexRecords
+	if s.isIndexPartitioned() {
+		partitionIdx := searchIndex(s.indexRecords, key)
+		if partitionIdx == len(s.indexRecords) {
+			return shared.IndexRecord{}, false, nil
+		}
+
+		var err error
+		records, err = s.indexPartition(partitionIdx)
+		if err != nil {
+			return shared.IndexRecord{}, false, err
+		}
+	}
+
+	blockIdx := searchIndex(records, key)
+	if blockIdx == len(records) {
+		return shared.IndexRecord{}, false, nil
+	}
+	return records[blockIdx], true, nil
+}
+
+// mayContain consults the bloom filter, or the filter partition covering key
+// when filters are partitioned.
+func (s *SSTable) mayContain(key shared.Key) (bool, error) {
+	if !s.isFilterPartitioned() {
+		return s.filter == nil || s.filter.Contains(string(key)), nil
+	}
+
+	partitionIdx := searchIndex(s.filterIndex, key)
+	if partitionIdx == len(s.filterIndex) {
+		return false, nil
+	}
+
+	record := s.filterIndex[partitionIdx]
+	cacheKey := blockKey{path: s.path, offset: record.Offset}
+	if cached, ok := s.blockCache.get(cacheKey); ok {
+		return cached.(*Filter).Contains(string(key)), nil
+	}
+
+	filterBytes := make([]byte, record.Size)
+	if err := s.readAt(filterBytes, record.Offset); err != nil {
+		return false, err
+	}
+	filter, err := Decode(filterBytes)
+	if err != nil {
+		return false, err
+	}
+
+	// the decoded bitset takes a byte per bit
+	s.blockCache.put(cacheKey, filter, len(filterBytes)*8)
+	return filter.Contains(string(key)), nil
+}
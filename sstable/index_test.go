@@ -0,0 +1,118 @@
+package sstable
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func partitionedConfig() *SSTableConfig {
+	config := defaultTableConfig()
+	config.DataBlockSize = 256
+	config.PartitionedIndex = true
+	config.PartitionedFilter = true
+	config.MetadataBlockSize = 128
+	return config
+}
+
+func writePartitionedTable(t *testing.T, n int) *SSTable {
+	t.Helper()
+	var entries []shared.Entry
+	for i := 0; i < n; i++ {
+		entries = append(entries, shared.Entry{
+			Key:     shared.Key(fmt.Sprintf("key%05d", i*2)),
+			Value:   []byte(fmt.Sprintf("value%d", i)),
+			Version: i + 1,
+		})
+	}
+	path := filepath.Join(t.TempDir(), TableFileName(1))
+	writeTestTable(t, path, partitionedConfig(), entries...)
+
+	table, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.blockCache = NewBlockCache(1 << 20)
+	t.Cleanup(func() { table.Close() })
+	return table
+}
+
+func TestPartitionedIndexFindsEveryKey(t *testing.T) {
+	table := writePartitionedTable(t, 2000)
+
+	if table.meta.IndexPartitions < 2 || table.meta.FilterPartitions < 2 {
+		t.Fatalf("table has %d index and %d filter partitions, want several",
+			table.meta.IndexPartitions, table.meta.FilterPartitions)
+	}
+	if !table.isIndexPartitioned() || !table.isFilterPartitioned() {
+		t.Fatal("reader did not pick up the partitioned layout")
+	}
+	if len(table.indexRecords) != int(table.meta.IndexPartitions) {
+		t.Fatalf("top-level index has %d records for %d partitions", len(table.indexRecords), table.meta.IndexPartitions)
+	}
+
+	for i := 0; i < 2000; i += 97 {
+		key := shared.Key(fmt.Sprintf("key%05d", i*2))
+		entry, err := table.Get(key)
+		if err != nil || entry == nil || string(entry.Value) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("%s reads as %+v, %v", key, entry, err)
+		}
+	}
+	for _, missing := range []string{"key00001", "key01999", "key99999", "a"} {
+		entry, err := table.Get(shared.Key(missing))
+		if err != nil || entry != nil {
+			t.Fatalf("missing key %s reads as %+v, %v", missing, entry, err)
+		}
+	}
+}
+
+func TestPartitionedIndexIteratorSeeksAcrossPartitions(t *testing.T) {
+	table := writePartitionedTable(t, 2000)
+
+	it, err := table.newIteratorAt(shared.Key("key02001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	want := 1001
+	for {
+		entry, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		if expected := fmt.Sprintf("key%05d", want*2); string(entry.Key) != expected {
+			t.Fatalf("iterator returned %s, want %s", entry.Key, expected)
+		}
+		want++
+		count++
+	}
+	if count != 999 {
+		t.Fatalf("iterator returned %d entries, want 999", count)
+	}
+}
+
+func TestPartitionsLoadedThroughBlockCache(t *testing.T) {
+	table := writePartitionedTable(t, 2000)
+
+	if table.blockCache.used != 0 {
+		t.Fatal("partitions were cached before the first read")
+	}
+	if _, err := table.Get(shared.Key("key01000")); err != nil {
+		t.Fatal(err)
+	}
+	used := table.blockCache.used
+	if used == 0 {
+		t.Fatal("the read did not cache the partitions it loaded")
+	}
+	if _, err := table.Get(shared.Key("key01000")); err != nil {
+		t.Fatal(err)
+	}
+	if table.blockCache.used != used {
+		t.Fatal("a repeated read loaded its partitions again")
+	}
+}
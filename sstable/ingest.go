@@ -0,0 +1,283 @@
+package sstable
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// SstFileWriter writes an SSTable outside of any engine, to be added with
+// IngestExternalFiles. Keys must be added in strictly increasing order.
+type SstFileWriter struct {
+	path    string
+	writer  *BlockWriter
+	lastKey shared.Key
+	entries int
+}
+
+// NewSstFileWriter creates the table at path using the block and compression
+// settings of the last level of opts, where bulk data usually ends up.
+func NewSstFileWriter(path string, opts *Options) (*SstFileWriter, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	config := levelConfig(defaultTableConfig(), opts, opts.NumLevels-1)
+	// values stay in the table since blob files are not ingested
+	config.MinBlobSize = 0
+	config.RateLimiter = nil
+
+	writer, err := NewBlockWriter(path, config)
+	if err != nil {
+		return nil, err
+	}
+	return &SstFileWriter{path: path, writer: writer}, nil
+}
+
+func (w *SstFileWriter) Put(key shared.Key, value []byte) error {
+	return w.add(shared.Entry{Key: key, Value: value})
+}
+
+func (w *SstFileWriter) Delete(key shared.Key) error {
+	return w.add(shared.Entry{Key: key, Tombstone: true})
+}
+
+// DeleteRange deletes [start, end) from the data the table is ingested over.
+func (w *SstFileWriter) DeleteRange(start, end shared.Key) error {
+	if start.Compare(end) >= 0 {
+		return fmt.Errorf("invalid range: %q is not before %q", start, end)
+	}
+	w.writer.AddRangeTombstone(shared.RangeTombstone{Start: start, End: end})
+	w.entries++
+	return nil
+}
+
+func (w *SstFileWriter) add(entry shared.Entry) error {
+	if w.lastKey != nil && entry.Key.Compare(w.lastKey) <= 0 {
+		return fmt.Errorf("keys must be added in increasing order: %q after %q", entry.Key, w.lastKey)
+	}
+	if err := w.writer.Add(entry); err != nil {
+		return err
+	}
+	w.lastKey = append(shared.Key(nil), entry.Key...)
+	w.entries++
+	return nil
+}
+
+// Finish completes the table. A table without entries is removed and
+// reported as an error.
+func (w *SstFileWriter) Finish() error {
+	if err := w.writer.Finish(); err != nil {
+		return err
+	}
+	if w.entries == 0 {
+		os.Remove(w.path)
+		return errors.New("cannot finish an SST file without entries")
+	}
+	return nil
+}
+
+// ExternalFile is a table written by SstFileWriter that passed validation
+// and can be ingested.
+type ExternalFile struct {
+	Path   string
+	MinKey shared.Key
+	MaxKey shared.Key
+	meta   shared.MetaBlock
+}
+
+// OpenExternalFiles validates the tables at paths for ingestion: each must be
+// a readable, non-empty table without blob references, and their key ranges
+// must not overlap.
+func OpenExternalFiles(paths []string) ([]*ExternalFile, error) {
+	files := make([]*ExternalFile, 0, len(paths))
+	for _, path := range paths {
+		table, err := Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid external file %s: %w", path, err)
+		}
+		table.Close()
+
+		switch {
+		case table.meta.EntryCount == 0 && table.meta.RangeDelCount == 0:
+			return nil, fmt.Errorf("external file %s is empty", path)
+		case len(table.meta.BlobReferences) > 0:
+			return nil, fmt.Errorf("external file %s refers to blob files", path)
+		}
+		files = append(files, &ExternalFile{
+			Path:   path,
+			MinKey: table.meta.MinKey,
+			MaxKey: table.meta.MaxKey,
+			meta:   table.meta,
+		})
+	}
+
+	sorted := append([]*ExternalFile(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].MinKey.Compare(sorted[j].MinKey) < 0
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i-1].MaxKey.Compare(sorted[i].MinKey) >= 0 {
+			return nil, fmt.Errorf("external files %s and %s overlap", sorted[i-1].Path, sorted[i].Path)
+		}
+	}
+	return files, nil
+}
+
+// IngestOptions controls how IngestExternalFiles takes in its files.
+type IngestOptions struct {
+	// MoveFiles moves the files into the database instead of copying them,
+	// so they are gone from their original paths afterwards.
+	MoveFiles bool
+}
+
+// IngestExternalFiles adds files to the database, all with version sequence,
+// in one version edit. It is PrepareIngestion followed by Apply.
+func (m *SSManager) IngestExternalFiles(files []*ExternalFile, sequence uint64, opts *IngestOptions) error {
+	ingestion, err := m.PrepareIngestion(files, opts)
+	if err != nil {
+		return err
+	}
+	return ingestion.Apply(sequence)
+}
+
+// Ingestion holds external files already copied or moved into the database
+// directory. They are not part of the database until Apply; Abort gives them
+// up.
+type Ingestion struct {
+	m       *SSManager
+	files   []*ExternalFile
+	paths   []string
+	numbers []uint64
+	move    bool
+}
+
+// PrepareIngestion puts files into the database directory under new table
+// numbers without holding any lock, so copying large files does not hold up
+// reads, writes or compactions. The database never shares a file with a
+// path the caller still owns.
+func (m *SSManager) PrepareIngestion(files []*ExternalFile, opts *IngestOptions) (*Ingestion, error) {
+	if opts == nil {
+		opts = &IngestOptions{}
+	}
+
+	ingestion := &Ingestion{m: m, move: opts.MoveFiles}
+	for _, file := range files {
+		m.mu.Lock()
+		number := m.newPendingOutput()
+		m.mu.Unlock()
+		ingestion.numbers = append(ingestion.numbers, number)
+
+		path := filepath.Join(m.dir, TableFileName(number))
+		if err := ingestFile(file.Path, path, opts.MoveFiles); err != nil {
+			ingestion.Abort()
+			return nil, fmt.Errorf("failed to ingest external file %s: %w", file.Path, err)
+		}
+		ingestion.files = append(ingestion.files, file)
+		ingestion.paths = append(ingestion.paths, path)
+	}
+	return ingestion, nil
+}
+
+// Apply installs the prepared files with version sequence. Each goes to the
+// deepest level where it overlaps no table in that level or above, so only
+// older data lies below it. The sequence is recorded in the manifest, not in
+// the files.
+//
+// Apply does not wait for a running compaction. Its inputs stay in their
+// levels until it installs, and ingestLevel keeps files out of the range its
+// output will take.
+func (i *Ingestion) Apply(sequence uint64) error {
+	m := i.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	edit := &versionEdit{}
+	for j, file := range i.files {
+		table := &tableFile{path: i.paths[j], meta: file.meta}
+		table.meta.GlobalSequence = sequence
+		table.meta.MinSequence = sequence
+		table.meta.MaxSequence = sequence
+		m.cache.setGlobalSequence(table.path, sequence)
+
+		// files of one ingestion don't overlap, so the edit can be built
+		// against the current version
+		level := m.ingestLevel(table)
+		edit.addFile(level, table)
+		log.Printf("Ingesting %s into level %d", file.Path, level)
+	}
+	if err := m.logAndApply(edit); err != nil {
+		i.undo()
+		return fmt.Errorf("failed to record ingestion: %w", err)
+	}
+	i.release()
+
+	m.scheduleCompaction()
+	m.listSSTables()
+	return nil
+}
+
+// Abort removes the prepared files, or moves them back if they were moved.
+func (i *Ingestion) Abort() {
+	i.m.mu.Lock()
+	defer i.m.mu.Unlock()
+	i.undo()
+}
+
+// undo gives up the prepared files. The caller holds m.mu.
+func (i *Ingestion) undo() {
+	for j, file := range i.files {
+		i.m.cache.Evict(i.paths[j])
+		if _, err := os.Stat(file.Path); err == nil || !i.move {
+			os.Remove(i.paths[j])
+		} else if err := ingestFile(i.paths[j], file.Path, true); err != nil {
+			log.Printf("Warning: failed to move %s back to %s: %v", i.paths[j], file.Path, err)
+		}
+	}
+	i.release()
+}
+
+func (i *Ingestion) release() {
+	for _, number := range i.numbers {
+		delete(i.m.pendingOutputs, number)
+	}
+}
+
+// ingestLevel returns the deepest level table can be added to without
+// overlapping any table in that level or above it, or the output of the
+// running compaction.
+func (m *SSManager) ingestLevel(table *tableFile) int {
+	if m.opts.CompactionStyle == UniversalCompaction {
+		return 0
+	}
+
+	level := 0
+	for l := 0; l < m.opts.NumLevels; l++ {
+		if len(m.overlappingFiles(l, table.meta.MinKey, table.meta.MaxKey)) > 0 ||
+			m.running.outputOverlaps(l, table.meta.MinKey, table.meta.MaxKey) {
+			break
+		}
+		level = l
+	}
+	return level
+}
+
+// ingestFile puts the external file src at dst. It is copied unless move is
+// set, in which case it is renamed, or copied and removed across file
+// systems.
+func ingestFile(src, dst string, move bool) error {
+	if move && os.Rename(src, dst) == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	if move {
+		return os.Remove(src)
+	}
+	return nil
+}
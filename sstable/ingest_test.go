@@ -0,0 +1,178 @@
+package sstable
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// writeExternalFile writes keys, given in order, with value to a new file
+// for ingestion.
+func writeExternalFile(t *testing.T, path, value string, keys ...string) {
+	t.Helper()
+	writer, err := NewSstFileWriter(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := writer.Put(shared.Key(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ingest(t *testing.T, m *SSManager, sequence uint64, opts *IngestOptions, paths ...string) {
+	t.Helper()
+	files, err := OpenExternalFiles(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.IngestExternalFiles(files, sequence, opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertIngested(t *testing.T, m *SSManager, key, value string, version int) {
+	t.Helper()
+	entry, err := m.Get(shared.Key(key))
+	if err != nil || entry == nil || string(entry.Value) != value || entry.Version != version {
+		t.Fatalf("%s reads as %+v, %v; want %q at version %d", key, entry, err, value, version)
+	}
+}
+
+func TestIngestedFileLinkedAboveOlderOverlappingData(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 3, put("a", 1), put("b", 2))
+	addTable(t, m, 1, put("m", 3))
+
+	path := filepath.Join(t.TempDir(), "bulk.sst")
+	writer, err := NewSstFileWriter(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if err := writer.Put(shared.Key(key), []byte("bulk")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Put(shared.Key("b"), nil); err == nil {
+		t.Fatal("out of order key was accepted")
+	}
+	if err := writer.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	ingest(t, m, 10, nil, path)
+
+	if len(m.sstables[2]) != 1 {
+		t.Fatalf("level 2 holds %d tables, want the ingested one", len(m.sstables[2]))
+	}
+	assertIngested(t, m, "a", "bulk", 10)
+}
+
+func TestIngestLeavesSourceUntouched(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 1, put("a", 1))
+
+	path := filepath.Join(t.TempDir(), "nightly.sst")
+	writeExternalFile(t, path, "monday", "a", "b")
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ingest(t, m, 10, nil, path)
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatalf("ingestion changed the source file from %d to %d bytes", len(before), len(after))
+	}
+
+	// the next run of the job rewrites the same path
+	writeExternalFile(t, path, "tuesday", "a")
+	assertIngested(t, m, "b", "monday", 10)
+
+	m = reopen(t, m)
+	assertIngested(t, m, "a", "monday", 10)
+	assertIngested(t, m, "b", "monday", 10)
+}
+
+func TestIngestMoveFiles(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 1, put("a", 1))
+
+	path := filepath.Join(t.TempDir(), "bulk.sst")
+	writeExternalFile(t, path, "bulk", "a")
+	ingest(t, m, 10, &IngestOptions{MoveFiles: true}, path)
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("moved file still at its source path: %v", err)
+	}
+	assertIngested(t, m, "a", "bulk", 10)
+}
+
+func TestIngestedSequenceSurvivesCompaction(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 0, put("b", 5), put("d", 20))
+
+	path := filepath.Join(t.TempDir(), "bulk.sst")
+	writeExternalFile(t, path, "bulk", "a", "b", "c")
+	ingest(t, m, 10, nil, path)
+
+	compactInto(t, m, 0, 1, m.sstables[0]...)
+
+	if len(m.sstables[0]) != 0 {
+		t.Fatalf("level 0 holds %d tables after compacting", len(m.sstables[0]))
+	}
+	assertIngested(t, m, "a", "bulk", 10)
+	assertIngested(t, m, "b", "bulk", 10)
+	assertIngested(t, m, "d", "d-value", 20)
+	if got := m.MaxSequence(); got != 20 {
+		t.Fatalf("MaxSequence returned %d, want 20", got)
+	}
+}
+
+func TestIngestDoesNotWaitForCompaction(t *testing.T) {
+	m := newTestManager(t, nil)
+	gapped := []*tableFile{
+		addTable(t, m, 0, put("a", 1), put("b", 1)),
+		addTable(t, m, 0, put("x", 2), put("y", 2)),
+	}
+
+	// a compaction of both runs into level 1 is merging
+	m.compactionMu.Lock()
+	defer m.compactionMu.Unlock()
+	m.mu.Lock()
+	m.running = &compaction{level: 0, outputLevel: 1, inputs: gapped}
+	m.mu.Unlock()
+
+	path := filepath.Join(t.TempDir(), "bulk.sst")
+	writeExternalFile(t, path, "bulk", "m", "n")
+	done := make(chan struct{})
+	go func() {
+		ingest(t, m, 10, nil, path)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ingestion waited for the running compaction")
+	}
+
+	// level 1 would be overlapped by the compaction output spanning a to y
+	if len(m.sstables) != 1 || len(m.sstables[0]) != 3 {
+		t.Fatalf("ingested file not in level 0: %d levels, %d tables in level 0",
+			len(m.sstables), len(m.sstables[0]))
+	}
+	assertIngested(t, m, "m", "bulk", 10)
+}
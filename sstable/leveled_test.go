@@ -0,0 +1,148 @@
+package sstable
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func leveledOptions() *Options {
+	opts := DefaultOptions()
+	opts.NumLevels = 4
+	opts.Level0CompactionTrigger = 2
+	opts.MaxBytesForLevelBase = 1024
+	opts.MaxBytesForLevelMultiplier = 4
+	return opts
+}
+
+// sequentialEntries returns count entries with keys prefix00000,
+// prefix00001, ...
+func sequentialEntries(prefix string, count, version int) []shared.Entry {
+	entries := make([]shared.Entry, count)
+	for i := range entries {
+		entries[i] = put(fmt.Sprintf("%s%05d", prefix, i), version)
+	}
+	return entries
+}
+
+func TestMaxBytesForLevelGrowsByMultiplier(t *testing.T) {
+	m := newTestManager(t, leveledOptions())
+	for level, want := range map[int]float64{1: 1024, 2: 4096, 3: 16384} {
+		if got := m.maxBytesForLevel(level); got != want {
+			t.Errorf("level %d: target %v, want %v", level, got, want)
+		}
+	}
+}
+
+func TestCompactionScore(t *testing.T) {
+	m := newTestManager(t, leveledOptions())
+	addTable(t, m, 0, put("a", 1))
+	if score := m.compactionScore(0); score != 0.5 {
+		t.Fatalf("level 0 with one of two tables scored %v", score)
+	}
+
+	table := addTable(t, m, 1, sequentialEntries("k", 50, 2)...)
+	want := float64(table.size()) / 1024
+	if score := m.compactionScore(1); score != want {
+		t.Fatalf("level 1 scored %v, want %v", score, want)
+	}
+
+	addTable(t, m, 3, sequentialEntries("k", 500, 3)...)
+	if score := m.compactionScore(3); score != 0 {
+		t.Fatalf("bottom level scored %v, want 0", score)
+	}
+}
+
+func TestPickLevelCompactionTakesHighestScore(t *testing.T) {
+	m := newTestManager(t, leveledOptions())
+	addTable(t, m, 0, put("a", 1))
+	if c := m.pickCompaction(); c != nil {
+		t.Fatalf("picked level %d with every level under its target", c.level)
+	}
+
+	addTable(t, m, 1, sequentialEntries("k", 200, 2)...)
+	overlapping := addTable(t, m, 2, put("k00050", 1))
+	addTable(t, m, 2, put("z", 1))
+
+	c := m.pickCompaction()
+	if c == nil || c.level != 1 || c.outputLevel != 2 {
+		t.Fatalf("picked %+v, want level 1 into level 2", c)
+	}
+	if len(c.overlapping) != 1 || c.overlapping[0] != overlapping {
+		t.Fatalf("picked %d overlapping tables, want only the one sharing keys", len(c.overlapping))
+	}
+	if c.maxFileSize != m.opts.TargetFileSize {
+		t.Fatalf("output tables capped at %d, want %d", c.maxFileSize, m.opts.TargetFileSize)
+	}
+}
+
+func TestPickFileCyclesThroughKeySpace(t *testing.T) {
+	m := newTestManager(t, leveledOptions())
+	first := addTable(t, m, 1, put("a", 1), put("b", 1))
+	second := addTable(t, m, 1, put("c", 1), put("d", 1))
+
+	for i, want := range []*tableFile{first, second, first} {
+		if got := m.pickFile(1); got != want {
+			t.Fatalf("pick %d returned %s, want %s", i, got.path, want.path)
+		}
+	}
+}
+
+func TestFixLevelsLeavesSortedLevels(t *testing.T) {
+	m := newTestManager(t, leveledOptions())
+	for version := 1; version <= 4; version++ {
+		addTable(t, m, 0, sequentialEntries("k", 100, version)...)
+	}
+	if err := m.fixLevels(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.sstables[0]) != 0 {
+		t.Fatalf("level 0 still holds %d tables", len(m.sstables[0]))
+	}
+	for level := 1; level < len(m.sstables); level++ {
+		if level < len(m.sstables)-1 && m.compactionScore(level) >= 1 {
+			t.Errorf("level %d left over its target", level)
+		}
+		tables := m.sstables[level]
+		for i := 1; i < len(tables); i++ {
+			if tables[i-1].meta.MaxKey.Compare(tables[i].meta.MinKey) >= 0 {
+				t.Errorf("level %d: tables %d and %d overlap", level, i-1, i)
+			}
+		}
+	}
+
+	entry, err := m.Get(shared.Key("k00042"))
+	if err != nil || entry == nil || entry.Version != 4 {
+		t.Fatalf("Get after compaction returned %+v, %v", entry, err)
+	}
+}
+
+func TestFlushSchedulesBackgroundCompaction(t *testing.T) {
+	m := newTestManager(t, leveledOptions())
+	addTable(t, m, 0, sequentialEntries("k", 100, 1)...)
+	if err := m.AddSSTable(writeFlush(t, m, sequentialEntries("k", 100, 2)...), m.NewFileNumber()); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.RLock()
+		pending := len(m.sstables[0])
+		m.mu.RUnlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("level 0 still holds %d tables", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entry, err := m.Get(shared.Key("k00042"))
+	if err != nil || entry == nil || entry.Version != 2 {
+		t.Fatalf("Get after compaction returned %+v, %v", entry, err)
+	}
+}
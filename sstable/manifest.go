@@ -0,0 +1,310 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The manifest is an append-only log of version edits. Replaying every edit
+// in order rebuilds the level layout. CURRENT names the live manifest file
+// and is replaced atomically whenever a new manifest is started.
+//
+// Each record is a uint32 payload length, a uint32 CRC-32 of the payload and
+// the payload itself, a sequence of tagged fields.
+
+const (
+	currentFileName    = "CURRENT"
+	legacyManifestName = "manifest"
+)
+
+const (
+	tagAddFile byte = iota + 1
+	tagDeleteFile
+	tagNextFileNumber
+	tagLogNumber
+)
+
+type versionEdit struct {
+	addedFiles     []fileEdit
+	deletedFiles   []fileEdit
+	nextFileNumber uint64
+	logNumber      uint64
+}
+
+type fileEdit struct {
+	level int
+	table *tableFile
+}
+
+func (e *versionEdit) addFile(level int, table *tableFile) {
+	e.addedFiles = append(e.addedFiles, fileEdit{level: level, table: table})
+}
+
+func (e *versionEdit) deleteFile(level int, table *tableFile) {
+	e.deletedFiles = append(e.deletedFiles, fileEdit{level: level, table: table})
+}
+
+func (e *versionEdit) encode() []byte {
+	buf := new(bytes.Buffer)
+	for _, deleted := range e.deletedFiles {
+		buf.WriteByte(tagDeleteFile)
+		binary.Write(buf, binary.LittleEndian, uint32(deleted.level))
+		writeBytes(buf, []byte(filepath.Base(deleted.table.path)))
+	}
+	for _, added := range e.addedFiles {
+		buf.WriteByte(tagAddFile)
+		binary.Write(buf, binary.LittleEndian, uint32(added.level))
+		writeBytes(buf, []byte(filepath.Base(added.table.path)))
+		writeBytes(buf, encodeMetaBlock(&added.table.meta))
+	}
+	if e.nextFileNumber > 0 {
+		buf.WriteByte(tagNextFileNumber)
+		binary.Write(buf, binary.LittleEndian, e.nextFileNumber)
+	}
+	if e.logNumber > 0 {
+		buf.WriteByte(tagLogNumber)
+		binary.Write(buf, binary.LittleEndian, e.logNumber)
+	}
+	return buf.Bytes()
+}
+
+func decodeVersionEdit(dir string, data []byte) (*versionEdit, error) {
+	edit := &versionEdit{}
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		tag, _ := reader.ReadByte()
+		switch tag {
+		case tagAddFile, tagDeleteFile:
+			var level uint32
+			if err := binary.Read(reader, binary.LittleEndian, &level); err != nil {
+				return nil, err
+			}
+			name, err := readBytes(reader)
+			if err != nil {
+				return nil, err
+			}
+			table := &tableFile{path: filepath.Join(dir, string(name))}
+
+			if tag == tagDeleteFile {
+				edit.deleteFile(int(level), table)
+				continue
+			}
+
+			metaBytes, err := readBytes(reader)
+			if err != nil {
+				return nil, err
+			}
+			if table.meta, err = decodeMetaBlock(metaBytes); err != nil {
+				return nil, err
+			}
+			edit.addFile(int(level), table)
+		case tagNextFileNumber:
+			if err := binary.Read(reader, binary.LittleEndian, &edit.nextFileNumber); err != nil {
+				return nil, err
+			}
+		case tagLogNumber:
+			if err := binary.Read(reader, binary.LittleEndian, &edit.logNumber); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown version edit tag %d", tag)
+		}
+	}
+	return edit, nil
+}
+
+func manifestFileName(number uint64) string {
+	return fmt.Sprintf("MANIFEST-%06d", number)
+}
+
+type manifestLog struct {
+	file   *os.File
+	number uint64
+}
+
+// createManifest starts a new manifest holding snapshot and points CURRENT
+// at it.
+func createManifest(dir string, number uint64, snapshot *versionEdit) (*manifestLog, error) {
+	path := filepath.Join(dir, manifestFileName(number))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest file: %w", err)
+	}
+
+	manifest := &manifestLog{file: file, number: number}
+	if err := manifest.append(snapshot); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := setCurrentFile(dir, number); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// append writes edit as one record and syncs it to disk.
+func (l *manifestLog) append(edit *versionEdit) error {
+	payload := edit.encode()
+	record := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+
+	if _, err := l.file.Write(record); err != nil {
+		return fmt.Errorf("failed to write manifest file: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync manifest file: %w", err)
+	}
+	return nil
+}
+
+func (l *manifestLog) close() error {
+	return l.file.Close()
+}
+
+func setCurrentFile(dir string, number uint64) error {
+	tmpPath := filepath.Join(dir, currentFileName+".dbtmp")
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create CURRENT file: %w", err)
+	}
+	if _, err := file.WriteString(manifestFileName(number) + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write CURRENT file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync CURRENT file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(dir, currentFileName)); err != nil {
+		return fmt.Errorf("failed to install CURRENT file: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// readManifest returns the name and edits of the manifest named by CURRENT.
+// A torn record at the end of the log, left by a crash mid-append, is ignored.
+func readManifest(dir string) (string, []*versionEdit, error) {
+	current, err := os.ReadFile(filepath.Join(dir, currentFileName))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read CURRENT file: %w", err)
+	}
+
+	name := strings.TrimSpace(string(current))
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open manifest %s: %w", name, err)
+	}
+
+	var edits []*versionEdit
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		var length, checksum uint32
+		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+			log.Printf("Warning: truncated record at the end of %s", name)
+			break
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &checksum); err != nil {
+			log.Printf("Warning: truncated record at the end of %s", name)
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			log.Printf("Warning: truncated record at the end of %s", name)
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != checksum {
+			if reader.Len() == 0 {
+				log.Printf("Warning: corrupt record at the end of %s", name)
+				break
+			}
+			return "", nil, errors.New("manifest corrupted: checksum mismatch")
+		}
+
+		edit, err := decodeVersionEdit(dir, payload)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to decode manifest %s: %w", name, err)
+		}
+		edits = append(edits, edit)
+	}
+
+	return name, edits, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// applyEdit returns a copy of levels with the file changes of edit
+// installed. levels itself is left untouched.
+func applyEdit(levels [][]*tableFile, edit *versionEdit) [][]*tableFile {
+	levels = append([][]*tableFile(nil), levels...)
+	for i := range levels {
+		levels[i] = append([]*tableFile{}, levels[i]...)
+	}
+
+	for _, deleted := range edit.deletedFiles {
+		if deleted.level >= len(levels) {
+			continue
+		}
+		level := levels[deleted.level]
+		for i, table := range level {
+			if table.path == deleted.table.path {
+				levels[deleted.level] = append(level[:i], level[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for _, added := range edit.addedFiles {
+		for len(levels) <= added.level {
+			levels = append(levels, []*tableFile{})
+		}
+		levels[added.level] = append(levels[added.level], added.table)
+	}
+
+	// level 0 stays in flush order; the tables of every other level cover
+	// disjoint key ranges and are kept sorted by them
+	for _, level := range levels[min(1, len(levels)):] {
+		sort.Slice(level, func(i, j int) bool {
+			return level[i].meta.MinKey.Compare(level[j].meta.MinKey) < 0
+		})
+	}
+	return levels
+}
+
+// snapshotEdit describes levels as a single edit, used as the first record
+// of every new manifest.
+func snapshotEdit(levels [][]*tableFile, nextFileNumber, logNumber uint64) *versionEdit {
+	edit := &versionEdit{
+		nextFileNumber: nextFileNumber,
+		logNumber:      logNumber,
+	}
+	for levelIdx, level := range levels {
+		for _, table := range level {
+			edit.addFile(levelIdx, table)
+		}
+	}
+	return edit
+}
@@ -0,0 +1,137 @@
+package sstable
+
+import (
+	"bytes"
+	"container/heap"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// Iterator yields entries in ascending key order. Next returns a nil entry
+// once the iterator is exhausted.
+type Iterator interface {
+	Next() (*shared.Entry, error)
+	Close() error
+}
+
+// RangeTombstoneIterator is implemented by iterators whose source also
+// holds range tombstones.
+type RangeTombstoneIterator interface {
+	Iterator
+	RangeTombstones() []shared.RangeTombstone
+}
+
+// MergingIterator merges any number of iterators into one sorted stream in
+// a single pass. When several children hold the same key only the entry
+// with the highest version is returned; on equal versions the child listed
+// first wins, so children are passed newest first. Entries deleted by a
+// range tombstone of any child are skipped.
+type MergingIterator struct {
+	children        []Iterator
+	heap            mergeHeap
+	rangeTombstones []shared.RangeTombstone
+}
+
+type mergeItem struct {
+	entry *shared.Entry
+	child int
+}
+
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].entry.Key, h[j].entry.Key); c != 0 {
+		return c < 0
+	}
+	if h[i].entry.Version != h[j].entry.Version {
+		return h[i].entry.Version > h[j].entry.Version
+	}
+	return h[i].child < h[j].child
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(mergeItem)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// NewMergingIterator takes ownership of children and closes them when it is
+// closed, including when it fails to start.
+func NewMergingIterator(children ...Iterator) (*MergingIterator, error) {
+	it := &MergingIterator{children: children}
+	for i, child := range children {
+		if source, ok := child.(RangeTombstoneIterator); ok {
+			it.rangeTombstones = append(it.rangeTombstones, source.RangeTombstones()...)
+		}
+
+		entry, err := child.Next()
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		if entry != nil {
+			it.heap = append(it.heap, mergeItem{entry: entry, child: i})
+		}
+	}
+	heap.Init(&it.heap)
+	return it, nil
+}
+
+func (it *MergingIterator) Next() (*shared.Entry, error) {
+	for len(it.heap) > 0 {
+		top := it.heap[0].entry
+		if err := it.advance(); err != nil {
+			return nil, err
+		}
+		// older versions of the same key sort right behind it
+		for len(it.heap) > 0 && bytes.Equal(it.heap[0].entry.Key, top.Key) {
+			if err := it.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		if top.Version >= shared.CoveringVersion(it.rangeTombstones, top.Key) {
+			return top, nil
+		}
+	}
+	return nil, nil
+}
+
+// RangeTombstones returns the range tombstones of every child.
+func (it *MergingIterator) RangeTombstones() []shared.RangeTombstone {
+	return it.rangeTombstones
+}
+
+// advance replaces the smallest entry with the next one of its child.
+func (it *MergingIterator) advance() error {
+	item := &it.heap[0]
+	entry, err := it.children[item.child].Next()
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		heap.Pop(&it.heap)
+		return nil
+	}
+	item.entry = entry
+	heap.Fix(&it.heap, 0)
+	return nil
+}
+
+func (it *MergingIterator) Close() error {
+	var firstError error
+	for _, child := range it.children {
+		if err := child.Close(); err != nil && firstError == nil {
+			firstError = err
+		}
+	}
+	it.heap = nil
+	return firstError
+}
@@ -0,0 +1,151 @@
+package sstable
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+type sliceIterator struct {
+	entries         []shared.Entry
+	rangeTombstones []shared.RangeTombstone
+	err             error
+	closed          bool
+}
+
+func (it *sliceIterator) Next() (*shared.Entry, error) {
+	if len(it.entries) == 0 {
+		return nil, it.err
+	}
+	entry := it.entries[0]
+	it.entries = it.entries[1:]
+	return &entry, nil
+}
+
+func (it *sliceIterator) RangeTombstones() []shared.RangeTombstone {
+	return it.rangeTombstones
+}
+
+func (it *sliceIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+func drain(t *testing.T, it Iterator) []shared.Entry {
+	t.Helper()
+	var entries []shared.Entry
+	for {
+		entry, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			return entries
+		}
+		entries = append(entries, *entry)
+	}
+}
+
+func assertEntries(t *testing.T, got []shared.Entry, want ...shared.Entry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if string(got[i].Key) != string(want[i].Key) || got[i].Version != want[i].Version ||
+			string(got[i].Value) != string(want[i].Value) {
+			t.Fatalf("entry %d is %s@%d=%q, want %s@%d=%q", i, got[i].Key, got[i].Version, got[i].Value,
+				want[i].Key, want[i].Version, want[i].Value)
+		}
+	}
+}
+
+func TestMergingIteratorKeepsNewestVersion(t *testing.T) {
+	older := &sliceIterator{entries: []shared.Entry{put("a", 1), put("b", 5), put("d", 2)}}
+	newer := &sliceIterator{entries: []shared.Entry{put("b", 3), put("c", 4), put("d", 6)}}
+
+	// children are not required to be in version order
+	it, err := NewMergingIterator(newer, older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	assertEntries(t, drain(t, it), put("a", 1), put("b", 5), put("c", 4), put("d", 6))
+}
+
+func TestMergingIteratorPrefersFirstChildOnEqualVersions(t *testing.T) {
+	first := &sliceIterator{entries: []shared.Entry{{Key: shared.Key("k"), Value: []byte("first"), Version: 7}}}
+	second := &sliceIterator{entries: []shared.Entry{{Key: shared.Key("k"), Value: []byte("second"), Version: 7}}}
+
+	it, err := NewMergingIterator(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	assertEntries(t, drain(t, it), shared.Entry{Key: shared.Key("k"), Value: []byte("first"), Version: 7})
+}
+
+func TestMergingIteratorAppliesRangeTombstonesAcrossChildren(t *testing.T) {
+	data := &sliceIterator{entries: []shared.Entry{put("a", 1), put("b", 2), put("c", 9), put("d", 3)}}
+	deletions := &sliceIterator{rangeTombstones: deleteRange("b", "d", 5)}
+
+	it, err := NewMergingIterator(deletions, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	// c was written after the range was deleted
+	assertEntries(t, drain(t, it), put("a", 1), put("c", 9), put("d", 3))
+	if got := len(it.RangeTombstones()); got != 1 {
+		t.Fatalf("merged iterator reports %d range tombstones, want 1", got)
+	}
+}
+
+func TestMergingIteratorClosesChildren(t *testing.T) {
+	healthy := &sliceIterator{entries: []shared.Entry{put("a", 1)}}
+	failing := &sliceIterator{err: errors.New("read failed")}
+
+	if _, err := NewMergingIterator(healthy, failing); err == nil {
+		t.Fatal("NewMergingIterator ignored a failing child")
+	}
+	if !healthy.closed || !failing.closed {
+		t.Fatal("children left open after NewMergingIterator failed")
+	}
+
+	children := []*sliceIterator{{entries: []shared.Entry{put("a", 1)}}, {}}
+	it, err := NewMergingIterator(children[0], children[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, child := range children {
+		if !child.closed {
+			t.Fatalf("child %d left open", i)
+		}
+	}
+}
+
+func TestMergingIteratorMergesTablesOfEveryLevel(t *testing.T) {
+	m := newTestManager(t, nil)
+	addTable(t, m, 2, put("a", 1), put("b", 1), put("c", 1))
+	addTable(t, m, 1, put("b", 2), del("c", 3))
+	addTable(t, m, 0, put("a", 4), put("d", 4))
+
+	iterators, err := m.NewIterators(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := NewMergingIterator(iterators...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	assertEntries(t, drain(t, it), put("a", 4), put("b", 2), del("c", 3), put("d", 4))
+}
@@ -0,0 +1,170 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// TablePropertiesCollector lets applications record their own statistics
+// while an SSTable is written. Add is called for every entry in key order and
+// Finish once the table is complete. The returned properties are stored in the
+// table's meta block under "<Name>.<key>".
+type TablePropertiesCollector interface {
+	Name() string
+	Add(entry shared.Entry)
+	Finish() map[string]string
+}
+
+// TablePropertiesCollectorFactory creates a fresh collector for each table.
+type TablePropertiesCollectorFactory func() TablePropertiesCollector
+
+func encodeMetaBlock(meta *shared.MetaBlock) []byte {
+	metaBuf := new(bytes.Buffer)
+	binary.Write(metaBuf, binary.LittleEndian, meta.EntryCount)
+	writeBytes(metaBuf, meta.MinKey)
+	writeBytes(metaBuf, meta.MaxKey)
+	binary.Write(metaBuf, binary.LittleEndian, meta.Timestamp)
+	binary.Write(metaBuf, binary.LittleEndian, meta.DictionarySize)
+	binary.Write(metaBuf, binary.LittleEndian, meta.DictionaryGain)
+	binary.Write(metaBuf, binary.LittleEndian, meta.RawDataSize)
+	binary.Write(metaBuf, binary.LittleEndian, meta.DataSize)
+	binary.Write(metaBuf, binary.LittleEndian, meta.DataBlockCount)
+	binary.Write(metaBuf, binary.LittleEndian, meta.TombstoneCount)
+	binary.Write(metaBuf, binary.LittleEndian, meta.RawKeySize)
+	binary.Write(metaBuf, binary.LittleEndian, meta.RawValueSize)
+	binary.Write(metaBuf, binary.LittleEndian, meta.Compression)
+	binary.Write(metaBuf, binary.LittleEndian, meta.MinSequence)
+	binary.Write(metaBuf, binary.LittleEndian, meta.MaxSequence)
+	binary.Write(metaBuf, binary.LittleEndian, meta.IndexPartitions)
+	binary.Write(metaBuf, binary.LittleEndian, meta.FilterPartitions)
+	binary.Write(metaBuf, binary.LittleEndian, meta.RangeDelCount)
+	binary.Write(metaBuf, binary.LittleEndian, meta.GlobalSequence)
+
+	blobFiles := make([]uint64, 0, len(meta.BlobReferences))
+	for number := range meta.BlobReferences {
+		blobFiles = append(blobFiles, number)
+	}
+	sort.Slice(blobFiles, func(i, j int) bool { return blobFiles[i] < blobFiles[j] })
+
+	binary.Write(metaBuf, binary.LittleEndian, uint32(len(blobFiles)))
+	for _, number := range blobFiles {
+		binary.Write(metaBuf, binary.LittleEndian, number)
+		binary.Write(metaBuf, binary.LittleEndian, meta.BlobReferences[number])
+	}
+
+	names := make([]string, 0, len(meta.UserProperties))
+	for name := range meta.UserProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	binary.Write(metaBuf, binary.LittleEndian, uint32(len(names)))
+	for _, name := range names {
+		writeBytes(metaBuf, []byte(name))
+		writeBytes(metaBuf, []byte(meta.UserProperties[name]))
+	}
+	return metaBuf.Bytes()
+}
+
+func decodeMetaBlock(data []byte) (shared.MetaBlock, error) {
+	var meta shared.MetaBlock
+	metaReader := bytes.NewReader(data)
+
+	if err := binary.Read(metaReader, binary.LittleEndian, &meta.EntryCount); err != nil {
+		return meta, err
+	}
+	minKey, err := readBytes(metaReader)
+	if err != nil {
+		return meta, err
+	}
+	meta.MinKey = shared.Key(minKey)
+	maxKey, err := readBytes(metaReader)
+	if err != nil {
+		return meta, err
+	}
+	meta.MaxKey = shared.Key(maxKey)
+
+	fields := []any{
+		&meta.Timestamp,
+		&meta.DictionarySize,
+		&meta.DictionaryGain,
+		&meta.RawDataSize,
+		&meta.DataSize,
+		&meta.DataBlockCount,
+		&meta.TombstoneCount,
+		&meta.RawKeySize,
+		&meta.RawValueSize,
+		&meta.Compression,
+		&meta.MinSequence,
+		&meta.MaxSequence,
+		&meta.IndexPartitions,
+		&meta.FilterPartitions,
+		&meta.RangeDelCount,
+		&meta.GlobalSequence,
+	}
+	for _, field := range fields {
+		if err := binary.Read(metaReader, binary.LittleEndian, field); err != nil {
+			return meta, err
+		}
+	}
+
+	var blobFileCount uint32
+	if err := binary.Read(metaReader, binary.LittleEndian, &blobFileCount); err != nil {
+		return meta, err
+	}
+	if blobFileCount > 0 {
+		meta.BlobReferences = make(map[uint64]uint64, blobFileCount)
+	}
+	for i := uint32(0); i < blobFileCount; i++ {
+		var number, size uint64
+		if err := binary.Read(metaReader, binary.LittleEndian, &number); err != nil {
+			return meta, err
+		}
+		if err := binary.Read(metaReader, binary.LittleEndian, &size); err != nil {
+			return meta, err
+		}
+		meta.BlobReferences[number] = size
+	}
+
+	var propertyCount uint32
+	if err := binary.Read(metaReader, binary.LittleEndian, &propertyCount); err != nil {
+		return meta, err
+	}
+	if propertyCount > 0 {
+		meta.UserProperties = make(map[string]string, propertyCount)
+	}
+	for i := uint32(0); i < propertyCount; i++ {
+		name, err := readBytes(metaReader)
+		if err != nil {
+			return meta, err
+		}
+		value, err := readBytes(metaReader)
+		if err != nil {
+			return meta, err
+		}
+		meta.UserProperties[string(name)] = string(value)
+	}
+
+	return meta, nil
+}
+
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+func readBytes(reader *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,99 @@
+package sstable
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// deletionCounter counts the tombstones it sees.
+type deletionCounter struct {
+	deletions int
+}
+
+func (c *deletionCounter) Name() string { return "deletions" }
+
+func (c *deletionCounter) Add(entry shared.Entry) {
+	if entry.Tombstone {
+		c.deletions++
+	}
+}
+
+func (c *deletionCounter) Finish() map[string]string {
+	return map[string]string{"count": strconv.Itoa(c.deletions)}
+}
+
+func TestTablePropertiesRecorded(t *testing.T) {
+	opts := DefaultOptions()
+	opts.TablePropertiesCollectors = []TablePropertiesCollectorFactory{
+		func() TablePropertiesCollector { return &deletionCounter{} },
+	}
+	m := newTestManager(t, opts)
+
+	table := addTableWithRanges(t, m, 0, deleteRange("x", "z", 9),
+		put("a", 3), del("b", 5), put("cc", 4))
+
+	meta := table.meta
+	if meta.EntryCount != 3 || meta.TombstoneCount != 1 || meta.RangeDelCount != 1 {
+		t.Fatalf("counts are %d entries, %d tombstones, %d range deletions; want 3, 1, 1",
+			meta.EntryCount, meta.TombstoneCount, meta.RangeDelCount)
+	}
+	if meta.MinSequence != 3 || meta.MaxSequence != 9 {
+		t.Fatalf("sequence range is [%d, %d], want [3, 9]", meta.MinSequence, meta.MaxSequence)
+	}
+	if meta.RawKeySize != 4 {
+		t.Fatalf("raw key size is %d, want 4", meta.RawKeySize)
+	}
+	// the range deletion widens the key range to its end
+	if string(meta.MinKey) != "a" || string(meta.MaxKey) != "z" {
+		t.Fatalf("key range is [%s, %s], want [a, z]", meta.MinKey, meta.MaxKey)
+	}
+	if got := meta.UserProperties["deletions.count"]; got != "1" {
+		t.Fatalf("collector recorded %q, want 1", got)
+	}
+
+	properties := m.GetPropertiesOfAllTables()
+	if !reflect.DeepEqual(properties[table.path], meta) {
+		t.Fatalf("GetPropertiesOfAllTables returned %+v for the table", properties[table.path])
+	}
+}
+
+func TestMetaBlockRoundTrip(t *testing.T) {
+	meta := shared.MetaBlock{
+		EntryCount:       10,
+		MinKey:           shared.Key("a"),
+		MaxKey:           shared.Key("z"),
+		Timestamp:        1234,
+		DictionarySize:   512,
+		DictionaryGain:   1.5,
+		RawDataSize:      4096,
+		DataSize:         1024,
+		DataBlockCount:   2,
+		TombstoneCount:   3,
+		RangeDelCount:    1,
+		RawKeySize:       20,
+		RawValueSize:     200,
+		Compression:      uint8(ZstdCompression),
+		MinSequence:      5,
+		MaxSequence:      50,
+		GlobalSequence:   60,
+		IndexPartitions:  4,
+		FilterPartitions: 4,
+		BlobReferences:   map[uint64]uint64{7: 100, 9: 300},
+		UserProperties:   map[string]string{"app.owner": "billing"},
+	}
+
+	decoded, err := decodeMetaBlock(encodeMetaBlock(&meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, meta) {
+		t.Fatalf("meta block decoded as %+v, want %+v", decoded, meta)
+	}
+
+	if _, err := decodeMetaBlock(encodeMetaBlock(&meta)[:20]); err == nil {
+		t.Fatal("truncated meta block was decoded")
+	}
+}
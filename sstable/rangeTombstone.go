@@ -0,0 +1,70 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// Range tombstones live in their own block, located by the footer, as a
+// sequence of start key, end key and uint64 version. Every table keeps them
+// in memory since a read must check them before consulting the filter.
+
+func encodeRangeTombstones(tombstones []shared.RangeTombstone) []byte {
+	buf := new(bytes.Buffer)
+	for _, tombstone := range tombstones {
+		writeBytes(buf, tombstone.Start)
+		writeBytes(buf, tombstone.End)
+		binary.Write(buf, binary.LittleEndian, uint64(tombstone.Version))
+	}
+	return buf.Bytes()
+}
+
+func decodeRangeTombstones(data []byte) ([]shared.RangeTombstone, error) {
+	var tombstones []shared.RangeTombstone
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		start, err := readBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		end, err := readBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		var version uint64
+		if err := binary.Read(reader, binary.LittleEndian, &version); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, shared.RangeTombstone{
+			Start:   shared.Key(start),
+			End:     shared.Key(end),
+			Version: int(version),
+		})
+	}
+	return tombstones, nil
+}
+
+// clipRangeTombstone returns the part of tombstone inside [lower, upper),
+// where nil bounds are open, and whether anything is left of it.
+func clipRangeTombstone(tombstone shared.RangeTombstone, lower, upper shared.Key) (shared.RangeTombstone, bool) {
+	if lower != nil && tombstone.Start.Compare(lower) < 0 {
+		tombstone.Start = lower
+	}
+	if upper != nil && tombstone.End.Compare(upper) > 0 {
+		tombstone.End = upper
+	}
+	return tombstone, tombstone.Start.Compare(tombstone.End) < 0
+}
+
+// overlapsRange reports whether the key range of any of tables intersects
+// [start, end). A nil end leaves the range open.
+func overlapsRange(tables []*tableFile, start, end shared.Key) bool {
+	for _, table := range tables {
+		if table.overlaps(start, end) {
+			return true
+		}
+	}
+	return false
+}
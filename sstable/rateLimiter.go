@@ -0,0 +1,129 @@
+package sstable
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// IOPriority orders the writers sharing a RateLimiter. Compaction output is
+// written at IOPriorityLow, flushes at IOPriorityHigh.
+type IOPriority int
+
+const (
+	IOPriorityLow IOPriority = iota
+	IOPriorityHigh
+)
+
+// rateLimiterRefillPeriod is how often the bucket is refilled; it also
+// bounds the burst to one period's worth of bytes.
+const rateLimiterRefillPeriod = 100 * time.Millisecond
+
+// RateLimiter is a token bucket shared by every table writer. Low priority
+// requests wait while a high priority one is queued, so flushes are not
+// starved by compactions. A nil *RateLimiter does not limit anything.
+type RateLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	available      int64
+	lastRefill     time.Time
+	highWaiting    int
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{
+		bytesPerSecond: bytesPerSecond,
+		lastRefill:     time.Now(),
+		now:            time.Now,
+	}
+}
+
+// SetBytesPerSecond changes the rate for all following requests. Zero or
+// less removes the limit.
+func (r *RateLimiter) SetBytesPerSecond(bytesPerSecond int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refill(r.now())
+	r.bytesPerSecond = bytesPerSecond
+	r.available = min(r.available, r.burst())
+}
+
+func (r *RateLimiter) BytesPerSecond() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bytesPerSecond
+}
+
+// Request blocks until n bytes may be written at priority.
+func (r *RateLimiter) Request(n int, priority IOPriority) {
+	if r == nil {
+		return
+	}
+
+	queued := false
+	defer func() {
+		if queued {
+			r.mu.Lock()
+			r.highWaiting--
+			r.mu.Unlock()
+		}
+	}()
+
+	remaining := int64(n)
+	for remaining > 0 {
+		r.mu.Lock()
+		if r.bytesPerSecond <= 0 {
+			r.mu.Unlock()
+			return
+		}
+		r.refill(r.now())
+
+		// requests larger than the burst are granted in pieces
+		chunk := min(remaining, r.burst())
+		if r.available >= chunk && (priority == IOPriorityHigh || r.highWaiting == 0) {
+			r.available -= chunk
+			remaining -= chunk
+			r.mu.Unlock()
+			continue
+		}
+
+		if priority == IOPriorityHigh && !queued {
+			r.highWaiting++
+			queued = true
+		}
+		wait := time.Duration(max(chunk-r.available, 1) * int64(time.Second) / r.bytesPerSecond)
+		r.mu.Unlock()
+		time.Sleep(min(wait, rateLimiterRefillPeriod))
+	}
+}
+
+func (r *RateLimiter) burst() int64 {
+	return max(r.bytesPerSecond/int64(time.Second/rateLimiterRefillPeriod), 1)
+}
+
+func (r *RateLimiter) refill(now time.Time) {
+	// one refill period fills the whole burst, so a longer idle time adds
+	// nothing more
+	elapsed := min(max(now.Sub(r.lastRefill), 0), rateLimiterRefillPeriod)
+	r.lastRefill = now
+	if r.bytesPerSecond <= 0 {
+		return
+	}
+	added := int64(elapsed.Seconds() * float64(r.bytesPerSecond))
+	r.available = min(max(r.available+added, 0), r.burst())
+}
+
+// rateLimitedWriter charges every write to the underlying file against a
+// RateLimiter.
+type rateLimitedWriter struct {
+	w        io.Writer
+	limiter  *RateLimiter
+	priority IOPriority
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	w.limiter.Request(len(p), w.priority)
+	return w.w.Write(p)
+}
@@ -0,0 +1,136 @@
+package sstable
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func TestRateLimiterRefillsAtRate(t *testing.T) {
+	limiter := NewRateLimiter(10000)
+
+	start := time.Now()
+	limiter.Request(3000, IOPriorityLow)
+	elapsed := time.Since(start)
+
+	// the bucket starts empty and holds at most 1000 bytes
+	if elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("3000 bytes at 10000 bytes/s took %v, want about 300ms", elapsed)
+	}
+}
+
+func TestRateLimiterServesHighPriorityFirst(t *testing.T) {
+	limiter := NewRateLimiter(10000)
+
+	var mu sync.Mutex
+	var order []IOPriority
+	var wg sync.WaitGroup
+	request := func(priority IOPriority) {
+		defer wg.Done()
+		limiter.Request(2000, priority)
+		mu.Lock()
+		order = append(order, priority)
+		mu.Unlock()
+	}
+
+	wg.Add(2)
+	go request(IOPriorityLow)
+	time.Sleep(10 * time.Millisecond)
+	go request(IOPriorityHigh)
+	wg.Wait()
+
+	if order[0] != IOPriorityHigh {
+		t.Fatal("a low priority request queued first was served before a high priority one")
+	}
+}
+
+func TestRateLimiterSetBytesPerSecond(t *testing.T) {
+	limiter := NewRateLimiter(100)
+
+	done := make(chan struct{})
+	go func() {
+		limiter.Request(1<<20, IOPriorityLow)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	limiter.SetBytesPerSecond(0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a waiting request kept waiting after the limit was removed")
+	}
+	if got := limiter.BytesPerSecond(); got != 0 {
+		t.Fatalf("BytesPerSecond returned %d, want 0", got)
+	}
+
+	limiter.SetBytesPerSecond(10000)
+	start := time.Now()
+	limiter.Request(2000, IOPriorityHigh)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("2000 bytes at the new rate of 10000 bytes/s took only %v", elapsed)
+	}
+
+	var unlimited *RateLimiter
+	unlimited.Request(1<<30, IOPriorityLow)
+}
+
+func TestGetDoesNotWaitForThrottledCompaction(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CompressionPerLevel = []CompressionType{NoCompression}
+	opts.Level0CompactionTrigger = 2
+	opts.RateLimiter = NewRateLimiter(16 * 1024)
+	m := newTestManager(t, opts)
+	addTable(t, m, 0, sequentialEntries("k", 200, 1)...)
+	addTable(t, m, 0, sequentialEntries("k", 200, 2)...)
+
+	compacted := make(chan error, 1)
+	go func() { compacted <- m.fixLevels() }()
+
+	// wait for the compaction to start writing its output
+	for {
+		m.mu.RLock()
+		writing := len(m.pendingOutputs) > 0
+		m.mu.RUnlock()
+		if writing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	entry, err := m.Get(shared.Key("k00123"))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Get waited %v for the compaction", elapsed)
+	}
+	if err != nil || entry == nil || entry.Version != 2 {
+		t.Fatalf("Get during compaction returned %+v, %v", entry, err)
+	}
+
+	if err := <-compacted; err != nil {
+		t.Fatal(err)
+	}
+	if len(m.sstables[0]) != 0 {
+		t.Fatalf("level 0 holds %d tables after compacting", len(m.sstables[0]))
+	}
+}
+
+func TestRateLimiterAfterLongIdle(t *testing.T) {
+	limiter := NewRateLimiter(100 << 20)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	limiter.lastRefill = now
+
+	now = now.Add(2 * time.Minute)
+
+	start := time.Now()
+	limiter.Request(4096, IOPriorityHigh)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("a high priority request after two idle minutes waited %v", elapsed)
+	}
+	if limiter.available < 0 || limiter.available > limiter.burst() {
+		t.Fatalf("%d bytes available, want between 0 and the burst of %d", limiter.available, limiter.burst())
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"os"
-	"sort"
 
 	"github.com/AmrMurad1/Go-Store/shared"
 	"github.com/klauspost/compress/s2"
@@ -14,10 +14,16 @@ import (
 
 type SSTable struct {
 	file         *os.File
+	path         string
 	indexRecords []shared.IndexRecord
 	meta         shared.MetaBlock
 	footer       shared.Footer
 	filter       *Filter
+	filterIndex  []shared.IndexRecord
+	dictionary   *zstdDictionary
+	blockCache   *BlockCache
+
+	rangeTombstones []shared.RangeTombstone
 }
 
 func Open(filename string) (*SSTable, error) {
@@ -33,74 +39,61 @@ func Open(filename string) (*SSTable, error) {
 
 	sstable := &SSTable{
 		file: file,
+		path: filename,
 	}
 
-	//read footer
-	footerBytes := make([]byte, shared.FooterSize)
-	if _, err := file.ReadAt(footerBytes, stat.Size()-int64(shared.FooterSize)); err != nil {
-		return nil, err
+	sstable.footer, err = readFooter(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("table %s: %w", filename, err)
 	}
-	footerReader := bytes.NewReader(footerBytes)
-	if err := binary.Read(footerReader, binary.LittleEndian, &sstable.footer); err != nil {
+
+	metaBytes := make([]byte, sstable.footer.MetaBlockSize)
+	if _, err := file.ReadAt(metaBytes, sstable.footer.MetaBlockOffset); err != nil {
 		return nil, err
 	}
-
-	if sstable.footer.Magic != shared.MagicNumber {
-		return nil, errors.New("invalid sstable file: magic number mismatch")
+	if sstable.isLegacy() {
+		sstable.meta, err = decodeLegacyMetaBlock(metaBytes)
+	} else {
+		sstable.meta, err = decodeMetaBlock(metaBytes)
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	// with a partitioned index only the top-level index is kept in memory
 	indexBytes := make([]byte, sstable.footer.IndexBlockSize)
 	if _, err := file.ReadAt(indexBytes, sstable.footer.IndexBlockOffset); err != nil {
 		return nil, err
 	}
-	indexReader := bytes.NewReader(indexBytes)
-	for indexReader.Len() > 0 {
-		var keyLen uint32
-		if err := binary.Read(indexReader, binary.LittleEndian, &keyLen); err != nil {
+	sstable.indexRecords, err = decodeIndexRecords(indexBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if sstable.footer.DictionarySize > 0 {
+		dictionaryBytes := make([]byte, sstable.footer.DictionarySize)
+		if _, err := file.ReadAt(dictionaryBytes, sstable.footer.DictionaryOffset); err != nil {
 			return nil, err
 		}
-		key := make([]byte, keyLen)
-		if _, err := io.ReadFull(indexReader, key); err != nil {
+		sstable.dictionary, err = loadDictionary(dictionaryBytes)
+		if err != nil {
 			return nil, err
 		}
-		var record shared.IndexRecord
-		record.LastKey = shared.Key(key)
-		if err := binary.Read(indexReader, binary.LittleEndian, &record.Offset); err != nil {
+	}
+
+	if sstable.footer.RangeDelSize > 0 {
+		rangeDelBytes := make([]byte, sstable.footer.RangeDelSize)
+		if _, err := file.ReadAt(rangeDelBytes, sstable.footer.RangeDelOffset); err != nil {
 			return nil, err
 		}
-		if err := binary.Read(indexReader, binary.LittleEndian, &record.Size); err != nil {
+		sstable.rangeTombstones, err = decodeRangeTombstones(rangeDelBytes)
+		if err != nil {
 			return nil, err
 		}
-		sstable.indexRecords = append(sstable.indexRecords, record)
-	}
-
-	metaBytes := make([]byte, sstable.footer.MetaBlockSize)
-	if _, err := file.ReadAt(metaBytes, sstable.footer.MetaBlockOffset); err != nil {
-		return nil, err
-	}
-	metaReader := bytes.NewReader(metaBytes)
-	if err := binary.Read(metaReader, binary.LittleEndian, &sstable.meta.EntryCount); err != nil {
-		return nil, err
-	}
-	var minKeyLen, maxKeyLen uint32
-	if err := binary.Read(metaReader, binary.LittleEndian, &minKeyLen); err != nil {
-		return nil, err
-	}
-	minKey := make([]byte, minKeyLen)
-	if _, err := io.ReadFull(metaReader, minKey); err != nil {
-		return nil, err
-	}
-	sstable.meta.MinKey = shared.Key(minKey)
-	if err := binary.Read(metaReader, binary.LittleEndian, &maxKeyLen); err != nil {
-		return nil, err
-	}
-	maxKey := make([]byte, maxKeyLen)
-	if _, err := io.ReadFull(metaReader, maxKey); err != nil {
-		return nil, err
-	}
-	sstable.meta.MaxKey = shared.Key(maxKey)
-	if err := binary.Read(metaReader, binary.LittleEndian, &sstable.meta.Timestamp); err != nil {
-		return nil, err
+		for i := range sstable.rangeTombstones {
+			sstable.rangeTombstones[i].Version = sstable.version(uint64(sstable.rangeTombstones[i].Version))
+		}
 	}
 
 	filterBytes := make([]byte, sstable.footer.FilterSize)
@@ -108,7 +101,15 @@ func Open(filename string) (*SSTable, error) {
 		return nil, err
 	}
 
-	sstable.filter, err = Decode(filterBytes)
+	switch {
+	case sstable.isLegacy():
+		// legacy filters don't record their hash count, so every key may
+		// be present
+	case sstable.isFilterPartitioned():
+		sstable.filterIndex, err = decodeIndexRecords(filterBytes)
+	default:
+		sstable.filter, err = Decode(filterBytes)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -121,32 +122,26 @@ func (s *SSTable) Get(key shared.Key) (*shared.Entry, error) {
 		return nil, nil
 	}
 
-	if !s.filter.Contains(string(key)) {
-		return nil, nil
+	mayContain, err := s.mayContain(key)
+	if err != nil {
+		return nil, err
 	}
-
-	indexRecordIndex := sort.Search(len(s.indexRecords), func(i int) bool {
-		return s.indexRecords[i].LastKey.Compare(key) >= 0
-	})
-
-	if indexRecordIndex == len(s.indexRecords) {
+	if !mayContain {
 		return nil, nil
 	}
 
-	record := s.indexRecords[indexRecordIndex]
-	dataBlockBytes := make([]byte, record.Size)
-	_, err := s.file.ReadAt(dataBlockBytes, record.Offset)
-	if err != nil {
+	record, found, err := s.findDataBlock(key)
+	if err != nil || !found {
 		return nil, err
 	}
 
-	decompressedBlock, err := s2.Decode(nil, dataBlockBytes)
+	decompressedBlock, err := s.readDataBlock(record, true)
 	if err != nil {
 		return nil, err
 	}
 
 	blockReader := bytes.NewReader(decompressedBlock)
-	var prevKey shared.Key
+	prevKey := s.blockPrefixKey(record)
 	for blockReader.Len() > 0 {
 		var lcp, suffixLen uint16
 		if err := binary.Read(blockReader, binary.LittleEndian, &lcp); err != nil {
@@ -173,8 +168,8 @@ func (s *SSTable) Get(key shared.Key) (*shared.Entry, error) {
 			return nil, err
 		}
 
-		var tombstone bool
-		if err := binary.Read(blockReader, binary.LittleEndian, &tombstone); err != nil {
+		kind, version, err := s.readKindAndVersion(blockReader)
+		if err != nil {
 			return nil, err
 		}
 
@@ -182,7 +177,9 @@ func (s *SSTable) Get(key shared.Key) (*shared.Entry, error) {
 			return &shared.Entry{
 				Key:       currentKey,
 				Value:     value,
-				Tombstone: tombstone,
+				Tombstone: kind == entryKindTombstone,
+				Version:   s.version(version),
+				BlobIndex: kind == entryKindBlobIndex,
 			}, nil
 		}
 		prevKey = currentKey
@@ -191,6 +188,69 @@ func (s *SSTable) Get(key shared.Key) (*shared.Entry, error) {
 	return nil, nil
 }
 
+// readDataBlock returns the decompressed data block. Blocks are served from
+// the block cache when possible and added to it when fillCache is set;
+// compactions read without filling it so they don't evict the working set.
+func (s *SSTable) readDataBlock(record shared.IndexRecord, fillCache bool) ([]byte, error) {
+	key := blockKey{path: s.path, offset: record.Offset}
+	if cached, ok := s.blockCache.get(key); ok {
+		return cached.([]byte), nil
+	}
+
+	dataBlockBytes := make([]byte, record.Size)
+	if err := s.readAt(dataBlockBytes, record.Offset); err != nil {
+		return nil, err
+	}
+	var block []byte
+	var err error
+	if s.isLegacy() {
+		block, err = s2.Decode(nil, dataBlockBytes)
+	} else {
+		block, err = decompressBlock(dataBlockBytes, s.dictionary)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if fillCache {
+		s.blockCache.put(key, block, len(block))
+	}
+	return block, nil
+}
+
+// version returns the version of an entry stored with version stored, which
+// the global sequence of an ingested table overrides.
+func (s *SSTable) version(stored uint64) int {
+	if s.meta.GlobalSequence > 0 {
+		return int(s.meta.GlobalSequence)
+	}
+	return int(stored)
+}
+
+// setGlobalSequence makes every entry and range tombstone of an ingested
+// table read with version sequence.
+func (s *SSTable) setGlobalSequence(sequence uint64) {
+	s.meta.GlobalSequence = sequence
+	s.meta.MinSequence = sequence
+	s.meta.MaxSequence = sequence
+	for i := range s.rangeTombstones {
+		s.rangeTombstones[i].Version = int(sequence)
+	}
+}
+
+// readAt reads len(p) bytes at off, returning ErrClosed once the table has
+// been closed underneath its reader.
+func (s *SSTable) readAt(p []byte, off int64) error {
+	_, err := s.file.ReadAt(p, off)
+	if errors.Is(err, os.ErrClosed) {
+		return ErrClosed
+	}
+	return err
+}
+
 func (s *SSTable) Close() error {
+	if s.dictionary != nil {
+		s.dictionary.close()
+	}
 	return s.file.Close()
 }
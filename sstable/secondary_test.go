@@ -0,0 +1,42 @@
+package sstable
+
+import (
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func TestReadOnlyManagerRefreshFollowsWriter(t *testing.T) {
+	m := newTestManager(t, nil)
+	first := addTable(t, m, 0, put("a", 1))
+
+	reader, err := NewReadOnlySSManager(m.dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	addTable(t, m, 0, put("b", 2))
+	compactInto(t, m, 0, 1, first, m.sstables[0][1])
+	if entry, _ := reader.Get(shared.Key("b")); entry != nil {
+		t.Fatalf("b is visible before a refresh: %+v", entry)
+	}
+
+	if err := reader.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.sstables[0]) != 0 || len(reader.sstables[1]) != len(m.sstables[1]) {
+		t.Fatalf("refreshed reader has %d tables in level 0 and %d in level 1, want 0 and %d",
+			len(reader.sstables[0]), len(reader.sstables[1]), len(m.sstables[1]))
+	}
+	for _, key := range []string{"a", "b"} {
+		entry, err := reader.Get(shared.Key(key))
+		if err != nil || entry == nil {
+			t.Fatalf("%s reads as %+v, %v after a refresh", key, entry, err)
+		}
+	}
+
+	if err := m.Refresh(); err == nil {
+		t.Fatal("a writable manager was refreshed")
+	}
+}
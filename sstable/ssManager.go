@@ -2,22 +2,176 @@ package sstable
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/AmrMurad1/Go-Store/shared"
 )
 
 type SSManager struct {
-	mu       sync.RWMutex
-	sstables [][]*SSTable
-	dir      string
-	config   *SSTableConfig
+	mu             sync.RWMutex
+	sstables       [][]*tableFile
+	dir            string
+	config         *SSTableConfig
+	cache          *TableCache
+	opts           *Options
+	manifest       *manifestLog
+	nextFileNumber uint64
+	logNumber      uint64
+	// compactPointers holds, per level, the largest key of the last table
+	// compacted out of it so that the next compaction picks the one after.
+	compactPointers map[int]shared.Key
+
+	// pendingOutputs are the numbers of tables written outside the lock that
+	// AddSSTable, a compaction or an ingestion has not installed yet.
+	pendingOutputs map[uint64]bool
+
+	// running is the compaction merging outside mu, if any. Ingestion keeps
+	// clear of the key range its output will take.
+	running *compaction
+
+	// compactionMu serializes compactions. Compactions merge
+	// while holding it but not mu, so reads and flushes go on meanwhile.
+	compactionMu sync.Mutex
+	// compactionScheduled wakes the background compaction goroutine.
+	compactionScheduled chan struct{}
+
+	// readOnly managers never write to dir; Refresh picks up the changes
+	// made by the process that does.
+	readOnly bool
+	// lock keeps other writers out of dir. Read-only managers don't take it.
+	lock *fileLock
+
+	// stop ends the background goroutines tracked by background.
+	stop       chan struct{}
+	stopOnce   sync.Once
+	background sync.WaitGroup
+}
+
+// tableFile describes an SSTable that belongs to a level. The file itself is
+// opened on demand through the table cache.
+type tableFile struct {
+	path string
+	meta shared.MetaBlock
+}
+
+func (t *tableFile) size() uint64 {
+	return t.meta.DataSize
+}
+
+// overlaps reports whether the table may hold keys in [start, end). A nil
+// end leaves the range open.
+func (t *tableFile) overlaps(start, end shared.Key) bool {
+	return (end == nil || t.meta.MinKey.Compare(end) < 0) && t.meta.MaxKey.Compare(start) >= 0
+}
+
+type Options struct {
+	MaxOpenFiles int
+	// CompressionPerLevel picks the block codec for each level. Levels past
+	// the end of the slice use its last entry.
+	CompressionPerLevel []CompressionType
+	// ZstdDictionarySize trains a dictionary with up to this many bytes of
+	// sampled content for every zstd-compressed compaction output; its
+	// header and entropy tables come on top. Zero disables it.
+	ZstdDictionarySize int
+	// TablePropertiesCollectors are run for every table written by flushes
+	// and compactions.
+	TablePropertiesCollectors []TablePropertiesCollectorFactory
+	// BlockCacheSize is the capacity in bytes of the cache shared by all
+	// tables for data blocks and index/filter partitions.
+	BlockCacheSize int64
+	// PartitionedIndex and PartitionedFilter keep only a small top-level
+	// index in memory and load partitions of MetadataBlockSize bytes on
+	// demand, which keeps opening large tables cheap.
+	PartitionedIndex  bool
+	PartitionedFilter bool
+	MetadataBlockSize int
+	// NumLevels is the number of levels in the tree. The last one has no
+	// size target and only receives compaction output.
+	NumLevels int
+	// Level0CompactionTrigger is the number of level 0 tables that starts a
+	// compaction into level 1.
+	Level0CompactionTrigger int
+	// MaxBytesForLevelBase is the target size of level 1. Each following
+	// level targets MaxBytesForLevelMultiplier times the size of the one
+	// above it.
+	MaxBytesForLevelBase       uint64
+	MaxBytesForLevelMultiplier float64
+	// TargetFileSize is the size at which compaction starts a new output
+	// table.
+	TargetFileSize uint64
+	// CompactionFilter, when set, may drop or rewrite every entry that a
+	// compaction keeps.
+	CompactionFilter CompactionFilter
+
+	// CompactionStyle chooses between leveled and universal compaction.
+	CompactionStyle CompactionStyle
+	// UniversalSizeRatio is the percentage by which a run may be larger than
+	// the newer runs picked so far and still be merged with them.
+	UniversalSizeRatio int
+	// UniversalMinMergeWidth is the fewest runs merged by one size-ratio
+	// compaction.
+	UniversalMinMergeWidth int
+	// UniversalMaxSizeAmplificationPercent bounds the size of all runs but
+	// the oldest, as a percentage of the oldest. Past it every run is merged.
+	UniversalMaxSizeAmplificationPercent int
+
+	// MaxSubcompactions is the most goroutines one compaction is split
+	// across. Each one merges a key range of at least TargetFileSize bytes.
+	// A CompactionFilter must be safe for concurrent use when it is above 1.
+	MaxSubcompactions int
+
+	// RateLimiter, when set, throttles the table writes of flushes and
+	// compactions. Its rate can be changed while the engine runs.
+	RateLimiter *RateLimiter
+
+	// TombstoneCompactionRatio compacts a table once tombstones make up at
+	// least this fraction of its entries, whatever the size of its level.
+	// Zero disables it.
+	TombstoneCompactionRatio float64
+	// PeriodicCompactionAge compacts tables written longer ago than this.
+	// Zero disables it.
+	PeriodicCompactionAge time.Duration
+
+	// MinBlobSize stores values of at least this many bytes in blob files,
+	// leaving only a reference in the table, so compactions don't copy them.
+	// Zero keeps every value in its table.
+	MinBlobSize int
+	// BlobGarbageCollectionRatio is the share of unreferenced bytes in a blob
+	// file past which compactions move its remaining values to new blob
+	// files. Zero disables blob garbage collection.
+	BlobGarbageCollectionRatio float64
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		MaxOpenFiles:        1000,
+		CompressionPerLevel: []CompressionType{S2Compression},
+		BlockCacheSize:      8 * 1024 * 1024,
+		MetadataBlockSize:   4096,
+
+		NumLevels:                  7,
+		Level0CompactionTrigger:    4,
+		MaxBytesForLevelBase:       10 * 1024 * 1024,
+		MaxBytesForLevelMultiplier: 10,
+		TargetFileSize:             2 * 1024 * 1024,
+
+		UniversalSizeRatio:                   1,
+		UniversalMinMergeWidth:               2,
+		UniversalMaxSizeAmplificationPercent: 200,
+
+		MaxSubcompactions: 1,
+
+		BlobGarbageCollectionRatio: 0.5,
+	}
 }
 
 func createPath(dataPath string) error {
@@ -27,31 +181,97 @@ func createPath(dataPath string) error {
 	return nil
 }
 
-func (m *SSManager) writeManifestFile() error {
-	manifestPath := filepath.Join(m.dir, "manifest")
-	file, err := os.OpenFile(manifestPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+func (m *SSManager) loadTableFile(path string) (*tableFile, error) {
+	handle, err := m.cache.Acquire(path)
 	if err != nil {
-		return fmt.Errorf("failed to create manifest file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	defer handle.Release()
 
-	err = binary.Write(file, binary.LittleEndian, int64(len(m.sstables)))
-	if err != nil {
-		return fmt.Errorf("failed to write manifest file: %w", err)
+	return &tableFile{
+		path: path,
+		meta: handle.meta,
+	}, nil
+}
+
+// recover rebuilds the level layout by replaying the manifest named by
+// CURRENT, then starts a fresh manifest holding a snapshot of it.
+func (m *SSManager) recover() error {
+	if _, err := os.Stat(filepath.Join(m.dir, currentFileName)); err == nil {
+		if m.sstables, m.nextFileNumber, m.logNumber, err = loadVersion(m.dir); err != nil {
+			return err
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		levels, err := m.recoverLegacy()
+		if err != nil {
+			return err
+		}
+
+		// older layouts allowed overlapping tables in every level; start
+		// them all in level 0, oldest first, and let compaction sort them
+		var tables []*tableFile
+		for levelIdx := len(levels) - 1; levelIdx >= 0; levelIdx-- {
+			tables = append(tables, levels[levelIdx]...)
+		}
+		m.sstables = [][]*tableFile{tables}
+	} else {
+		return fmt.Errorf("failed to stat CURRENT file: %w", err)
 	}
 
+	if len(m.sstables) == 0 {
+		m.sstables = [][]*tableFile{{}}
+	}
+	// refuse to start on tables that can't be read rather than let
+	// compaction and obsolete file cleanup lose them
 	for _, level := range m.sstables {
-		err = binary.Write(file, binary.LittleEndian, int64(len(level)))
-		if err != nil {
-			return fmt.Errorf("failed to write manifest file: %w", err)
+		for _, table := range level {
+			if err := checkTableFormat(table.path); err != nil {
+				return err
+			}
 		}
 	}
+	m.setGlobalSequences(m.sstables)
+	if err := m.skipUsedFileNumbers(); err != nil {
+		return err
+	}
 
+	number := m.newFileNumber()
+	manifest, err := createManifest(m.dir, number, snapshotEdit(m.sstables, m.nextFileNumber, m.logNumber))
+	if err != nil {
+		return err
+	}
+	m.manifest = manifest
+
+	if err := os.Remove(filepath.Join(m.dir, legacyManifestName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: failed to remove old manifest %s: %v", legacyManifestName, err)
+	}
+
+	m.deleteObsoleteFiles()
 	return nil
 }
 
-func (m *SSManager) recover() ([][]*SSTable, error) {
-	manifestPath := filepath.Join(m.dir, "manifest")
+// loadVersion replays the manifest named by CURRENT and returns the levels,
+// next file number and log number it ends with.
+func loadVersion(dir string) ([][]*tableFile, uint64, uint64, error) {
+	_, edits, err := readManifest(dir)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var levels [][]*tableFile
+	var nextFileNumber, logNumber uint64
+	for _, edit := range edits {
+		levels = applyEdit(levels, edit)
+		nextFileNumber = max(nextFileNumber, edit.nextFileNumber)
+		logNumber = max(logNumber, edit.logNumber)
+	}
+	return levels, nextFileNumber, logNumber, nil
+}
+
+// recoverLegacy reads the layout from the per-level counts written by older
+// versions, which find tables by their level.position file names.
+func (m *SSManager) recoverLegacy() ([][]*tableFile, error) {
+	manifestPath := filepath.Join(m.dir, legacyManifestName)
 
 	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
 		return m.recoverFromFiles()
@@ -69,7 +289,7 @@ func (m *SSManager) recover() ([][]*SSTable, error) {
 		return nil, fmt.Errorf("failed to read manifest: %w", err)
 	}
 
-	levels := make([][]*SSTable, numLevels)
+	levels := make([][]*tableFile, numLevels)
 
 	for levelIdx := 0; levelIdx < int(numLevels); levelIdx++ {
 		var numSSTables int64
@@ -78,7 +298,7 @@ func (m *SSManager) recover() ([][]*SSTable, error) {
 			return nil, fmt.Errorf("failed to read manifest: %w", err)
 		}
 
-		level := make([]*SSTable, 0, numSSTables)
+		level := make([]*tableFile, 0, numSSTables)
 
 		for i := 0; i < int(numSSTables); i++ {
 			filename := fmt.Sprintf("%d.%d.sst", levelIdx, i)
@@ -89,13 +309,12 @@ func (m *SSManager) recover() ([][]*SSTable, error) {
 				continue
 			}
 
-			sstable, err := Open(fullPath)
+			table, err := m.loadTableFile(fullPath)
 			if err != nil {
-				log.Printf("Warning: failed to open SSTable %s: %v", filename, err)
-				continue
+				return nil, fmt.Errorf("failed to open SSTable %s: %w", filename, err)
 			}
 
-			level = append(level, sstable)
+			level = append(level, table)
 		}
 
 		levels[levelIdx] = level
@@ -104,10 +323,10 @@ func (m *SSManager) recover() ([][]*SSTable, error) {
 	return levels, nil
 }
 
-func (m *SSManager) recoverFromFiles() ([][]*SSTable, error) {
+func (m *SSManager) recoverFromFiles() ([][]*tableFile, error) {
 	files, err := os.ReadDir(m.dir)
 	if err != nil {
-		return [][]*SSTable{{}}, nil
+		return [][]*tableFile{{}}, nil
 	}
 
 	levelFiles := make(map[int][]string)
@@ -134,21 +353,20 @@ func (m *SSManager) recoverFromFiles() ([][]*SSTable, error) {
 		}
 	}
 
-	levels := make([][]*SSTable, maxLevel+1)
+	levels := make([][]*tableFile, maxLevel+1)
 
 	for level := 0; level <= maxLevel; level++ {
 		files := levelFiles[level]
 		sort.Strings(files)
 
-		levelSSTables := make([]*SSTable, 0, len(files))
+		levelSSTables := make([]*tableFile, 0, len(files))
 		for _, filename := range files {
 			fullPath := filepath.Join(m.dir, filename)
-			sstable, err := Open(fullPath)
+			table, err := m.loadTableFile(fullPath)
 			if err != nil {
-				log.Printf("Warning: failed to open SSTable %s: %v", filename, err)
-				continue
+				return nil, fmt.Errorf("failed to open SSTable %s: %w", filename, err)
 			}
-			levelSSTables = append(levelSSTables, sstable)
+			levelSSTables = append(levelSSTables, table)
 		}
 
 		levels[level] = levelSSTables
@@ -157,16 +375,17 @@ func (m *SSManager) recoverFromFiles() ([][]*SSTable, error) {
 	return levels, nil
 }
 
-func NewSSManager(dir string) (*SSManager, error) {
-	config := &SSTableConfig{
-		DataBlockSize:           4096,
-		FilterFalsePositiveRate: 0.01,
-		ExpectedEntryCount:      1000,
-	}
-
+func NewSSManager(dir string, opts *Options) (*SSManager, error) {
 	manager := &SSManager{
-		dir:    dir,
-		config: config,
+		dir:             dir,
+		config:          defaultTableConfig(),
+		cache:           NewTableCache(opts.MaxOpenFiles, NewBlockCache(opts.BlockCacheSize)),
+		opts:            opts,
+		compactPointers: make(map[int]shared.Key),
+		pendingOutputs:  make(map[uint64]bool),
+		stop:            make(chan struct{}),
+
+		compactionScheduled: make(chan struct{}, 1),
 	}
 
 	err := createPath(dir)
@@ -174,8 +393,38 @@ func NewSSManager(dir string) (*SSManager, error) {
 		return nil, err
 	}
 
-	manager.sstables, err = manager.recover()
-	if err != nil {
+	if manager.lock, err = lockFile(filepath.Join(dir, lockFileName)); err != nil {
+		return nil, err
+	}
+
+	if err := manager.recover(); err != nil {
+		manager.lock.unlock()
+		return nil, err
+	}
+
+	manager.background.Add(1)
+	go manager.runCompactions()
+
+	manager.listSSTables()
+	return manager, nil
+}
+
+// NewReadOnlySSManager opens the version recorded in dir without changing
+// anything there: no new manifest, no obsolete file cleanup and no
+// compactions. Another process may keep writing to dir.
+func NewReadOnlySSManager(dir string, opts *Options) (*SSManager, error) {
+	manager := &SSManager{
+		dir:             dir,
+		config:          defaultTableConfig(),
+		cache:           NewTableCache(opts.MaxOpenFiles, NewBlockCache(opts.BlockCacheSize)),
+		opts:            opts,
+		compactPointers: make(map[int]shared.Key),
+		pendingOutputs:  make(map[uint64]bool),
+		readOnly:        true,
+		stop:            make(chan struct{}),
+	}
+
+	if err := manager.Refresh(); err != nil {
 		return nil, err
 	}
 
@@ -183,32 +432,260 @@ func NewSSManager(dir string) (*SSManager, error) {
 	return manager, nil
 }
 
+// Refresh installs the version currently recorded in the manifest of a
+// read-only manager. Tables that left it are evicted from the table cache,
+// though readers still holding them keep them open.
+func (m *SSManager) Refresh() error {
+	if !m.readOnly {
+		return fmt.Errorf("only a read-only manager can be refreshed")
+	}
+
+	levels, nextFileNumber, logNumber, err := loadVersion(m.dir)
+	if err != nil {
+		return err
+	}
+	if len(levels) == 0 {
+		levels = [][]*tableFile{{}}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	live := make(map[string]bool)
+	for _, level := range levels {
+		for _, table := range level {
+			live[table.path] = true
+		}
+	}
+	for _, level := range m.sstables {
+		for _, table := range level {
+			if !live[table.path] {
+				m.cache.Evict(table.path)
+			}
+		}
+	}
+
+	m.setGlobalSequences(levels)
+	m.sstables = levels
+	m.nextFileNumber = nextFileNumber
+	m.logNumber = logNumber
+	return nil
+}
+
+// setGlobalSequences passes the sequence the manifest recorded for every
+// ingested table of levels on to the table cache.
+func (m *SSManager) setGlobalSequences(levels [][]*tableFile) {
+	for _, level := range levels {
+		for _, table := range level {
+			if table.meta.GlobalSequence > 0 {
+				m.cache.setGlobalSequence(table.path, table.meta.GlobalSequence)
+			}
+		}
+	}
+}
+
+// skipUsedFileNumbers moves nextFileNumber past every numbered file in the
+// directory, including ones allocated but never recorded before a crash.
+func (m *SSManager) skipUsedFileNumbers() error {
+	files, err := os.ReadDir(m.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	m.nextFileNumber = max(m.nextFileNumber, 1)
+	for _, file := range files {
+		if number, ok := parseFileNumber(file.Name()); ok {
+			m.nextFileNumber = max(m.nextFileNumber, number+1)
+		}
+	}
+	return nil
+}
+
+// TableFileName returns the name of the SSTable with the given file number.
+// Level membership is kept in the manifest, not in the name.
+func TableFileName(number uint64) string {
+	return fmt.Sprintf("%06d.sst", number)
+}
+
+// parseFileNumber extracts the number of a table, WAL or manifest file.
+func parseFileNumber(name string) (uint64, bool) {
+	name = strings.TrimPrefix(name, "MANIFEST-")
+	digits := name
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		digits = name[:dot]
+	}
+	if digits == "" {
+		return 0, false
+	}
+
+	number, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return number, true
+}
+
+func (m *SSManager) newFileNumber() uint64 {
+	number := m.nextFileNumber
+	m.nextFileNumber++
+	return number
+}
+
+// NewFileNumber allocates a file number that is never handed out again.
+func (m *SSManager) NewFileNumber() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.newFileNumber()
+}
+
+// NewTableFileName allocates the name of a table to be passed to AddSSTable.
+// Until then the table and its blob file are not deleted as obsolete.
+func (m *SSManager) NewTableFileName() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return TableFileName(m.newPendingOutput())
+}
+
+// newPendingOutput allocates the number of a table that is written outside
+// the lock. The caller removes it from pendingOutputs once the table is
+// installed or abandoned.
+func (m *SSManager) newPendingOutput() uint64 {
+	number := m.newFileNumber()
+	m.pendingOutputs[number] = true
+	return number
+}
+
+// LogNumber returns the oldest WAL number whose writes are not yet in an
+// SSTable.
+func (m *SSManager) LogNumber() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.logNumber
+}
+
+// logAndApply persists edit to the manifest and then installs it. The
+// directory entries of added files are synced first, so the manifest never
+// refers to a table that a crash could lose.
+func (m *SSManager) logAndApply(edit *versionEdit) error {
+	if len(edit.addedFiles) > 0 {
+		if err := syncDir(m.dir); err != nil {
+			return fmt.Errorf("failed to sync data directory: %w", err)
+		}
+	}
+
+	edit.nextFileNumber = m.nextFileNumber
+	if err := m.manifest.append(edit); err != nil {
+		return err
+	}
+
+	m.sstables = applyEdit(m.sstables, edit)
+	m.logNumber = max(m.logNumber, edit.logNumber)
+
+	m.deleteObsoleteFiles()
+	return nil
+}
+
+// LevelConfig returns the writer configuration for tables placed in level.
+func (m *SSManager) LevelConfig(level int) *SSTableConfig {
+	return levelConfig(m.config, m.opts, level)
+}
+
+func defaultTableConfig() *SSTableConfig {
+	return &SSTableConfig{
+		DataBlockSize:           4096,
+		FilterFalsePositiveRate: 0.01,
+		ExpectedEntryCount:      1000,
+	}
+}
+
+func levelConfig(base *SSTableConfig, opts *Options, level int) *SSTableConfig {
+	config := *base
+	if n := len(opts.CompressionPerLevel); n > 0 {
+		config.Compression = opts.CompressionPerLevel[min(level, n-1)]
+	}
+	config.DictionarySize = opts.ZstdDictionarySize
+	config.Collectors = opts.TablePropertiesCollectors
+	config.PartitionedIndex = opts.PartitionedIndex
+	config.PartitionedFilter = opts.PartitionedFilter
+	config.MetadataBlockSize = opts.MetadataBlockSize
+	config.RateLimiter = opts.RateLimiter
+	config.MinBlobSize = opts.MinBlobSize
+	return &config
+}
+
+// GetPropertiesOfAllTables returns the properties of every live table keyed
+// by file path.
+func (m *SSManager) GetPropertiesOfAllTables() map[string]shared.MetaBlock {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	properties := make(map[string]shared.MetaBlock)
+	for _, level := range m.sstables {
+		for _, table := range level {
+			properties[table.path] = table.meta
+		}
+	}
+	return properties
+}
+
+// MaxSequence returns the highest sequence number stored in any table.
+func (m *SSManager) MaxSequence() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sequence uint64
+	for _, level := range m.sstables {
+		for _, table := range level {
+			sequence = max(sequence, table.meta.MaxSequence)
+		}
+	}
+	return int(sequence)
+}
+
 func (m *SSManager) listSSTables() {
 	fmt.Println("SSTable layout:")
 	fmt.Printf("Total levels: %d\n", len(m.sstables))
 	for i, level := range m.sstables {
-		fmt.Printf("Level %d: %d SSTables\n", i, len(level))
+		fmt.Printf("Level %d: %d SSTables, %d bytes\n", i, len(level), levelSize(level))
 	}
 }
 
+// Get returns the newest live entry of key, or nil when the key is missing
+// or deleted by a tombstone or a range tombstone.
 func (m *SSManager) Get(key shared.Key) (*shared.Entry, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	// range tombstones seen so far delete anything below this version
+	deletedBelow := 0
 	for levelIdx, level := range m.sstables {
 		for i := len(level) - 1; i >= 0; i-- {
-			sstable := level[i]
-			entry, err := sstable.Get(key)
+			table := level[i]
+			if key.Compare(table.meta.MinKey) < 0 || key.Compare(table.meta.MaxKey) > 0 {
+				continue
+			}
+
+			handle, err := m.cache.Acquire(table.path)
+			if err != nil {
+				// skipping it could surface an older version of key
+				return nil, fmt.Errorf("failed to open SSTable in level %d: %w", levelIdx, err)
+			}
+			deletedBelow = max(deletedBelow, shared.CoveringVersion(handle.rangeTombstones, key))
+			entry, err := handle.Get(key)
+			handle.Release()
 			if err != nil {
 				log.Printf("Error searching SSTable in level %d, index %d: %v", levelIdx, i, err)
 				continue
 			}
 
 			if entry != nil {
-				if entry.Tombstone {
+				if entry.Tombstone || entry.Version < deletedBelow {
 					return nil, nil
 				}
 				log.Printf("Key found in level %d, SSTable %d", levelIdx, i)
+				if entry.BlobIndex {
+					return resolveBlob(m.dir, entry)
+				}
 				return entry, nil
 			}
 		}
@@ -217,104 +694,597 @@ func (m *SSManager) Get(key shared.Key) (*shared.Entry, error) {
 	return nil, nil
 }
 
-func (m *SSManager) AddSSTable(sstable *SSTable) error {
+// NewIterators returns an iterator for every table that may hold keys in
+// [start, end), newest first, each positioned at start. A nil end means no
+// upper bound. Every iterator keeps its table open until it is closed.
+func (m *SSManager) NewIterators(start, end shared.Key) ([]Iterator, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var iterators []Iterator
+	closeAll := func() {
+		for _, it := range iterators {
+			it.Close()
+		}
+	}
+
+	for _, level := range m.sstables {
+		for i := len(level) - 1; i >= 0; i-- {
+			table := level[i]
+			if table.meta.MaxKey.Compare(start) < 0 || (end != nil && table.meta.MinKey.Compare(end) >= 0) {
+				continue
+			}
+
+			handle, err := m.cache.Acquire(table.path)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			it, err := handle.newIteratorAt(start)
+			if err != nil {
+				handle.Release()
+				closeAll()
+				return nil, err
+			}
+			iterators = append(iterators, newTableIterator(it, handle))
+		}
+	}
+	return iterators, nil
+}
+
+// AddSSTable installs a flushed table in level 0. logNumber is the WAL of
+// the memtable that replaces the flushed one; older WALs are obsolete once
+// this returns. Compactions the new table calls for run in the background.
+func (m *SSManager) AddSSTable(path string, logNumber uint64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if len(m.sstables) == 0 {
-		m.sstables = append(m.sstables, []*SSTable{})
+	if number, ok := parseFileNumber(filepath.Base(path)); ok {
+		// installed or not, the table is no longer being written
+		defer delete(m.pendingOutputs, number)
 	}
 
-	m.sstables[0] = append(m.sstables[0], sstable)
-
-	err := m.fixLevels()
+	table, err := m.loadTableFile(path)
 	if err != nil {
-		return fmt.Errorf("compaction failed: %w", err)
+		return err
+	}
+
+	edit := &versionEdit{logNumber: logNumber}
+	edit.addFile(0, table)
+	if err := m.logAndApply(edit); err != nil {
+		return err
 	}
 
+	m.scheduleCompaction()
 	m.listSSTables()
 	return nil
 }
 
-func (m *SSManager) fixLevels() error {
-	for levelIdx := 0; levelIdx < len(m.sstables); levelIdx++ {
-		level := m.sstables[levelIdx]
+// CompactRange compacts every table holding keys in [start, end) into the
+// bottom level, level by level. Nil bounds leave that side of the range open.
+// Under universal compaction all level 0 runs are merged into one.
+func (m *SSManager) CompactRange(start, end shared.Key) error {
+	m.compactionMu.Lock()
+	defer m.compactionMu.Unlock()
+
+	if m.opts.CompactionStyle == UniversalCompaction {
+		m.mu.RLock()
+		runs := m.sstables[0]
+		m.mu.RUnlock()
+		if !overlapsRange(runs, start, end) {
+			return nil
+		}
+		return m.runCompaction(&compaction{level: 0, outputLevel: 0, inputs: runs, rewrite: true})
+	}
 
-		if len(level) >= 2 {
-			log.Printf("Starting compaction: level %d -> level %d", levelIdx, levelIdx+1)
+	m.mu.RLock()
+	bottom := 1
+	for level := range m.sstables {
+		if len(m.sstables[level]) > 0 {
+			bottom = max(bottom, level)
+		}
+	}
+	m.mu.RUnlock()
 
-			if len(m.sstables) == levelIdx+1 {
-				m.sstables = append(m.sstables, []*SSTable{})
-			}
+	for level := 0; level < bottom; level++ {
+		c := m.pickRangeCompaction(level, start, end)
+		if c == nil {
+			continue
+		}
+		if err := m.runCompaction(c); err != nil {
+			return fmt.Errorf("manual compaction failed: %w", err)
+		}
+	}
 
-			nextLevel := levelIdx + 1
-			newFilename := fmt.Sprintf("%s/%d.%d.sst", m.dir, nextLevel, len(m.sstables[nextLevel]))
+	m.mu.RLock()
+	m.listSSTables()
+	m.mu.RUnlock()
+	return nil
+}
 
-			compactedSSTable, err := m.compactSSTables(level, newFilename, nextLevel == len(m.sstables)-1)
-			if err != nil {
-				return fmt.Errorf("failed to compact level %d: %w", levelIdx, err)
-			}
+// pickRangeCompaction returns the compaction that moves the tables of level
+// holding keys in [start, end) into the next level, or nil if there are none.
+func (m *SSManager) pickRangeCompaction(level int, start, end shared.Key) *compaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-			if compactedSSTable != nil {
-				m.sstables[nextLevel] = append(m.sstables[nextLevel], compactedSSTable)
+	var inputs []*tableFile
+	if level == 0 {
+		// level 0 tables overlap each other, so they move down together
+		if overlapsRange(m.sstables[0], start, end) {
+			inputs = m.sstables[0]
+		}
+	} else if level < len(m.sstables) {
+		for _, table := range m.sstables[level] {
+			if table.overlaps(start, end) {
+				inputs = append(inputs, table)
 			}
+		}
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
 
-			for _, oldSSTable := range level {
-				oldSSTable.Close()
+	minKey, maxKey := keyRange(inputs)
+	return &compaction{
+		level:       level,
+		outputLevel: level + 1,
+		inputs:      inputs,
+		overlapping: m.overlappingFiles(level+1, minKey, maxKey),
+		maxFileSize: m.opts.TargetFileSize,
+		rewrite:     true,
+	}
+}
+
+// compaction merges the inputs of level, together with the tables of
+// outputLevel they overlap, into outputLevel.
+type compaction struct {
+	level       int
+	outputLevel int
+	inputs      []*tableFile
+	// overlapping are the tables of outputLevel that share keys with inputs.
+	overlapping []*tableFile
+	// maxFileSize caps the output tables; zero writes a single table.
+	maxFileSize uint64
+	// rewrite disables trivial moves so that deleted and overwritten
+	// entries are reclaimed, as manual compactions require.
+	rewrite bool
+}
+
+// fixLevels runs compactions until every level is back under its target and
+// no table is due for a tombstone or periodic compaction, or until the
+// manager is closed.
+func (m *SSManager) fixLevels() error {
+	m.compactionMu.Lock()
+	defer m.compactionMu.Unlock()
+
+	// tables written from here on are never aged, so the loop ends
+	cutoff := time.Now().Add(-m.opts.PeriodicCompactionAge)
+	for {
+		select {
+		case <-m.stop:
+			return nil
+		default:
+		}
+
+		m.mu.Lock()
+		c := m.pickCompaction()
+		if c == nil {
+			c = m.pickTriggeredCompaction(cutoff)
+		}
+		m.mu.Unlock()
+		if c == nil {
+			return nil
+		}
+		if err := m.runCompaction(c); err != nil {
+			return err
+		}
+	}
+}
+
+func levelSize(level []*tableFile) uint64 {
+	var size uint64
+	for _, table := range level {
+		size += table.size()
+	}
+	return size
+}
+
+// maxBytesForLevel returns the target size of level, which must be 1 or more.
+func (m *SSManager) maxBytesForLevel(level int) float64 {
+	size := float64(m.opts.MaxBytesForLevelBase)
+	for i := 1; i < level; i++ {
+		size *= m.opts.MaxBytesForLevelMultiplier
+	}
+	return size
+}
+
+// compactionScore tells how far level is over its target. A level scoring 1
+// or more needs a compaction. Level 0 is scored by table count since its
+// tables overlap and every one of them is searched on reads.
+func (m *SSManager) compactionScore(level int) float64 {
+	if level >= len(m.sstables) || level >= m.opts.NumLevels-1 {
+		return 0
+	}
+	if level == 0 {
+		return float64(len(m.sstables[0])) / float64(m.opts.Level0CompactionTrigger)
+	}
+	return float64(levelSize(m.sstables[level])) / m.maxBytesForLevel(level)
+}
+
+// pickCompaction returns the next compaction to run under the configured
+// style, or nil when none is needed.
+func (m *SSManager) pickCompaction() *compaction {
+	if m.opts.CompactionStyle == UniversalCompaction {
+		return m.pickUniversalCompaction()
+	}
+	return m.pickLevelCompaction()
+}
+
+// pickLevelCompaction chooses the level with the highest score and the
+// tables to compact out of it, or returns nil when no level needs one.
+func (m *SSManager) pickLevelCompaction() *compaction {
+	bestLevel, bestScore := -1, 1.0
+	for level := range m.sstables {
+		if score := m.compactionScore(level); score >= bestScore {
+			bestLevel, bestScore = level, score
+		}
+	}
+	if bestLevel < 0 {
+		return nil
+	}
+
+	c := &compaction{
+		level:       bestLevel,
+		outputLevel: bestLevel + 1,
+		maxFileSize: m.opts.TargetFileSize,
+	}
+	if bestLevel == 0 {
+		c.inputs = m.sstables[0]
+	} else {
+		c.inputs = []*tableFile{m.pickFile(bestLevel)}
+	}
+
+	minKey, maxKey := keyRange(c.inputs)
+	c.overlapping = m.overlappingFiles(bestLevel+1, minKey, maxKey)
+	return c
+}
+
+// pickFile returns the first table of level after the compact pointer,
+// wrapping around, so that successive compactions cycle through the key space.
+func (m *SSManager) pickFile(level int) *tableFile {
+	tables := m.sstables[level]
+	pointer, ok := m.compactPointers[level]
+	picked := tables[0]
+	if ok {
+		for _, table := range tables {
+			if table.meta.MinKey.Compare(pointer) > 0 {
+				picked = table
+				break
 			}
-			m.sstables[levelIdx] = []*SSTable{}
+		}
+	}
+	m.compactPointers[level] = picked.meta.MaxKey
+	return picked
+}
 
-			log.Printf("Level %d compacted successfully", levelIdx)
+func keyRange(tables []*tableFile) (shared.Key, shared.Key) {
+	minKey, maxKey := tables[0].meta.MinKey, tables[0].meta.MaxKey
+	for _, table := range tables[1:] {
+		if table.meta.MinKey.Compare(minKey) < 0 {
+			minKey = table.meta.MinKey
+		}
+		if table.meta.MaxKey.Compare(maxKey) > 0 {
+			maxKey = table.meta.MaxKey
 		}
 	}
+	return minKey, maxKey
+}
 
+// overlappingFiles returns the tables of level holding keys in
+// [minKey, maxKey].
+func (m *SSManager) overlappingFiles(level int, minKey, maxKey shared.Key) []*tableFile {
+	if level >= len(m.sstables) {
+		return nil
+	}
+
+	var tables []*tableFile
+	for _, table := range m.sstables[level] {
+		if table.meta.MaxKey.Compare(minKey) < 0 || table.meta.MinKey.Compare(maxKey) > 0 {
+			continue
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// olderTables returns the tables outside of c that may hold older versions
+// of its keys: every table below the output level and, for a universal
+// compaction, the level 0 runs older than its inputs. Tables of the output
+// level that c does not include cannot share keys with it.
+func (c *compaction) olderTables(m *SSManager) []*tableFile {
+	var older []*tableFile
+	if c.outputLevel == 0 {
+		older = append(older, m.sstables[0][:len(m.sstables[0])-len(c.inputs)]...)
+	}
+	for _, level := range m.sstables[min(c.outputLevel+1, len(m.sstables)):] {
+		older = append(older, level...)
+	}
+	return older
+}
+
+// newerRuns returns the level 0 runs added after the inputs of a universal
+// compaction were picked.
+func (c *compaction) newerRuns(m *SSManager) []*tableFile {
+	if c.outputLevel != 0 {
+		return nil
+	}
+	runs := m.sstables[0]
+	last := c.inputs[len(c.inputs)-1]
+	for i, run := range runs {
+		if run == last {
+			return runs[i+1:]
+		}
+	}
 	return nil
 }
 
-func (m *SSManager) compactSSTables(sstables []*SSTable, outputPath string, deleteTombstones bool) (*SSTable, error) {
-	if len(sstables) == 0 {
-		return nil, nil
+// outputOverlaps reports whether the output of c, which may span the whole
+// key range of its tables, goes to level and shares keys with
+// [minKey, maxKey].
+func (c *compaction) outputOverlaps(level int, minKey, maxKey shared.Key) bool {
+	if c == nil || c.outputLevel != level {
+		return false
+	}
+	start, end := keyRange(append(append([]*tableFile{}, c.inputs...), c.overlapping...))
+	return end.Compare(minKey) >= 0 && start.Compare(maxKey) <= 0
+}
+
+// coversKey reports whether the key range of any of tables includes key.
+func coversKey(tables []*tableFile, key shared.Key) bool {
+	for _, table := range tables {
+		if key.Compare(table.meta.MinKey) >= 0 && key.Compare(table.meta.MaxKey) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrivialMove reports whether the inputs can be moved to the output level
+// as they are: nothing in that level shares their keys, they do not overlap
+// each other, and no compaction filter wants to see their entries.
+func (c *compaction) isTrivialMove(m *SSManager) bool {
+	if c.rewrite || c.outputLevel == c.level || len(c.overlapping) > 0 || m.opts.CompactionFilter != nil {
+		return false
+	}
+	inputs := append([]*tableFile{}, c.inputs...)
+	sort.Slice(inputs, func(i, j int) bool {
+		return inputs[i].meta.MinKey.Compare(inputs[j].meta.MinKey) < 0
+	})
+	for i := 1; i < len(inputs); i++ {
+		if inputs[i-1].meta.MaxKey.Compare(inputs[i].meta.MinKey) >= 0 {
+			return false
+		}
 	}
+	return true
+}
 
-	if len(sstables) == 1 {
-		return sstables[0], nil
+// moveTables records the inputs of c in its output level without rewriting
+// them.
+func (m *SSManager) moveTables(c *compaction) error {
+	edit := &versionEdit{}
+	for _, table := range c.inputs {
+		edit.deleteFile(c.level, table)
+		edit.addFile(c.outputLevel, table)
+	}
+	if err := m.logAndApply(edit); err != nil {
+		return fmt.Errorf("failed to record move of level %d: %w", c.level, err)
 	}
 
-	merged := sstables[0]
-	var err error
+	log.Printf("Moved %d tables from level %d to level %d", len(c.inputs), c.level, c.outputLevel)
+	return nil
+}
 
-	for i := 1; i < len(sstables); i++ {
-		newOutput := fmt.Sprintf("%s.tmp.%d", outputPath, i)
+// runCompaction carries out c. The caller holds compactionMu, so no other
+// compaction or ingestion changes the levels c reads from and writes to. mu
+// is only held to read and install versions, not while merging: flushes may
+// add level 0 tables in the meantime.
+func (m *SSManager) runCompaction(c *compaction) error {
+	m.mu.Lock()
+	if c.isTrivialMove(m) {
+		defer m.mu.Unlock()
+		return m.moveTables(c)
+	}
+	// tables of the next level are older and go first so newer entries win
+	tables := append(append([]*tableFile{}, c.overlapping...), c.inputs...)
+	older := c.olderTables(m)
+	collect := m.blobFilesToCollect(tables)
+	m.running = c
+	m.mu.Unlock()
+
+	outputLevel := c.outputLevel
+	log.Printf("Starting compaction: level %d -> level %d (%d + %d tables)", c.level, outputLevel, len(c.inputs), len(c.overlapping))
+
+	var outputNumbers []uint64
+	newPath := func() string {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		number := m.newPendingOutput()
+		outputNumbers = append(outputNumbers, number)
+		return filepath.Join(m.dir, TableFileName(number))
+	}
+	outputs, err := m.compactSSTables(tables, older, collect, outputLevel, c.maxFileSize, newPath)
 
-		merged, err = compact(newOutput, merged, sstables[i], deleteTombstones, m.config)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer func() {
+		m.running = nil
+		for _, number := range outputNumbers {
+			delete(m.pendingOutputs, number)
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("failed to compact level %d: %w", c.level, err)
+	}
+
+	edit := &versionEdit{}
+	for _, table := range c.inputs {
+		edit.deleteFile(c.level, table)
+	}
+	for _, table := range c.overlapping {
+		edit.deleteFile(outputLevel, table)
+	}
+	for _, table := range outputs {
+		edit.addFile(outputLevel, table)
+	}
+	// runs flushed while a universal compaction merged are newer than its
+	// output and stay after it
+	for _, table := range c.newerRuns(m) {
+		edit.deleteFile(0, table)
+		edit.addFile(0, table)
+	}
+	if err := m.logAndApply(edit); err != nil {
+		return fmt.Errorf("failed to record compaction of level %d: %w", c.level, err)
+	}
+
+	for _, table := range tables {
+		m.cache.Evict(table.path)
+	}
+
+	log.Printf("Level %d compacted successfully into %d tables", c.level, len(outputs))
+	return nil
+}
+
+// compactSSTables merges tables, given oldest first, into new tables for
+// level of at most maxFileSize bytes each, returned in key order. Every
+// subcompaction makes a single pass over its key range. Values in the blob
+// files of collect are stored anew, and newPath names every output table.
+//
+// A tombstone is dropped once no table in older could still hold an earlier
+// version of its key. Open iterators never need it kept: they read from the
+// tables they pinned, which stay on disk until released.
+func (m *SSManager) compactSSTables(sstables []*tableFile, older []*tableFile, collect map[uint64]bool, level int, maxFileSize uint64, newPath func() string) ([]*tableFile, error) {
+	if len(sstables) == 0 {
+		return nil, nil
+	}
+	config := m.LevelConfig(level)
+
+	handles := make([]*TableHandle, 0, len(sstables))
+	inputs := make([]*SSTable, 0, len(sstables))
+	defer func() {
+		for _, handle := range handles {
+			handle.Release()
+		}
+	}()
+	for _, table := range sstables {
+		handle, err := m.cache.Acquire(table.path)
 		if err != nil {
 			return nil, err
 		}
+		handles = append(handles, handle)
+		inputs = append(inputs, handle.SSTable)
 	}
 
-	if err := os.Rename(fmt.Sprintf("%s.tmp.%d", outputPath, len(sstables)-1), outputPath); err != nil {
-		return nil, fmt.Errorf("failed to rename compacted SSTable: %w", err)
+	// range tombstones stay while an older table may hold keys they delete
+	var rangeTombstones []shared.RangeTombstone
+	for _, input := range inputs {
+		for _, tombstone := range input.rangeTombstones {
+			if overlapsRange(older, tombstone.Start, tombstone.End) {
+				rangeTombstones = append(rangeTombstones, tombstone)
+			}
+		}
+	}
+
+	var dictionary *zstdDictionary
+	if config.Compression == ZstdCompression && config.DictionarySize > 0 {
+		trained, err := trainDictionary(config.DictionarySize, inputs...)
+		if err != nil {
+			log.Printf("Warning: dictionary training failed, compressing without one: %v", err)
+		} else {
+			defer trained.close()
+			dictionary = trained
+		}
 	}
 
-	return Open(outputPath)
+	// large jobs that are split into several tables anyway run as parallel
+	// subcompactions over disjoint key ranges
+	var boundaries []shared.Key
+	if maxFileSize > 0 {
+		var inputSize uint64
+		for _, table := range sstables {
+			inputSize += table.size()
+		}
+		boundaries = subcompactionBoundaries(inputs, min(m.opts.MaxSubcompactions, int(inputSize/maxFileSize)))
+	}
+
+	outputs := make([]*compactionOutput, len(boundaries)+1)
+	for i := range outputs {
+		outputs[i] = &compactionOutput{
+			newPath:         newPath,
+			config:          config,
+			maxFileSize:     maxFileSize,
+			dictionary:      dictionary,
+			rangeTombstones: rangeTombstones,
+		}
+		if i > 0 {
+			outputs[i].lower = boundaries[i-1]
+		}
+		if i < len(boundaries) {
+			outputs[i].upper = boundaries[i]
+		}
+	}
+
+	errs := make([]error, len(outputs))
+	var wg sync.WaitGroup
+	for i, output := range outputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.runSubcompaction(inputs, older, level, collect, output)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var tables []*tableFile
+	for _, output := range outputs {
+		for _, path := range output.paths {
+			table, err := m.loadTableFile(path)
+			if err != nil {
+				return nil, err
+			}
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
 }
 
 func (m *SSManager) Close() error {
+	m.stopOnce.Do(func() { close(m.stop) })
+	m.background.Wait()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	var firstError error
 
-	for _, level := range m.sstables {
-		for _, sstable := range level {
-			if err := sstable.Close(); err != nil && firstError == nil {
-				firstError = err
-			}
+	if err := m.cache.Close(); err != nil {
+		firstError = err
+	}
+
+	if m.manifest != nil {
+		if err := m.manifest.close(); err != nil && firstError == nil {
+			firstError = err
 		}
 	}
 
-	if err := m.writeManifestFile(); err != nil && firstError == nil {
-		firstError = err
+	if m.lock != nil {
+		if err := m.lock.unlock(); err != nil && firstError == nil {
+			firstError = err
+		}
+		m.lock = nil
 	}
 
 	m.sstables = nil
@@ -0,0 +1,84 @@
+package sstable
+
+import (
+	"sort"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+// subcompactionBoundaries splits the key space of tables into at most n
+// ranges holding about the same number of index records and returns the
+// keys between them.
+func subcompactionBoundaries(tables []*SSTable, n int) []shared.Key {
+	if n <= 1 {
+		return nil
+	}
+
+	var keys []shared.Key
+	for _, table := range tables {
+		for _, record := range table.indexRecords {
+			keys = append(keys, record.LastKey)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Compare(keys[j]) < 0
+	})
+
+	var boundaries []shared.Key
+	for i := 1; i < n; i++ {
+		key := keys[i*len(keys)/n]
+		if len(boundaries) == 0 || key.Compare(boundaries[len(boundaries)-1]) > 0 {
+			boundaries = append(boundaries, key)
+		}
+	}
+	return boundaries
+}
+
+// runSubcompaction merges the entries of inputs, given oldest first, that
+// fall in the key range of output. Values in the blob files of collect are
+// read back so the output stores them anew.
+func (m *SSManager) runSubcompaction(inputs []*SSTable, older []*tableFile, level int, collect map[uint64]bool, output *compactionOutput) error {
+	children := make([]Iterator, 0, len(inputs))
+	for i := len(inputs) - 1; i >= 0; i-- {
+		it, err := inputs[i].newIteratorAt(output.lower)
+		if err != nil {
+			for _, child := range children {
+				child.Close()
+			}
+			return err
+		}
+		children = append(children, it)
+	}
+	merged, err := NewMergingIterator(children...)
+	if err != nil {
+		return err
+	}
+	defer merged.Close()
+
+	for {
+		entry, err := merged.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil || output.upper != nil && entry.Key.Compare(output.upper) >= 0 {
+			break
+		}
+		// filters see the value, not the reference
+		if entry.BlobIndex && (m.opts.CompactionFilter != nil || blobCollected(collect, entry)) {
+			if entry, err = resolveBlob(m.dir, entry); err != nil {
+				return err
+			}
+		}
+		entry = applyCompactionFilter(m.opts.CompactionFilter, level, entry, older)
+		if entry == nil || entry.Tombstone && !coversKey(older, entry.Key) {
+			continue
+		}
+		if err := output.add(*entry); err != nil {
+			return err
+		}
+	}
+	return output.finish()
+}
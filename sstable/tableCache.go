@@ -0,0 +1,222 @@
+package sstable
+
+import (
+	"container/list"
+	"errors"
+	"log"
+	"sync"
+)
+
+// ErrClosed is returned by reads from tables whose cache has been closed.
+var ErrClosed = errors.New("database is closed")
+
+// TableCache keeps a bounded number of SSTables open. Tables are opened on
+// first use and the least recently used idle ones are closed once more than
+// maxOpenFiles are open. A table is never closed while a handle to it is held.
+type TableCache struct {
+	mu           sync.Mutex
+	maxOpenFiles int
+	lru          *list.List
+	tables       map[string]*tableCacheEntry
+	// held counts the handles per path, including those to evicted tables
+	// that are only closed once released.
+	held map[string]int
+	// globalSequences holds the sequence of ingested tables, which the
+	// manifest records rather than the table file.
+	globalSequences map[string]uint64
+	blockCache      *BlockCache
+	closed          bool
+}
+
+type tableCacheEntry struct {
+	path     string
+	table    *SSTable
+	elem     *list.Element
+	refs     int
+	obsolete bool
+}
+
+// TableHandle is a reference-counted reader returned by TableCache.Acquire.
+// Release must be called once the caller is done with it.
+type TableHandle struct {
+	*SSTable
+	cache *TableCache
+	entry *tableCacheEntry
+}
+
+func NewTableCache(maxOpenFiles int, blockCache *BlockCache) *TableCache {
+	if maxOpenFiles <= 0 {
+		maxOpenFiles = 1
+	}
+	return &TableCache{
+		maxOpenFiles: maxOpenFiles,
+		lru:          list.New(),
+		tables:       make(map[string]*tableCacheEntry),
+		held:         make(map[string]int),
+
+		globalSequences: make(map[string]uint64),
+		blockCache:      blockCache,
+	}
+}
+
+// Acquire returns a handle to the table at path, opening it if it is not
+// cached. The file is opened without holding the cache lock.
+func (c *TableCache) Acquire(path string) (*TableHandle, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if entry, ok := c.tables[path]; ok {
+		handle := c.acquire(entry)
+		c.mu.Unlock()
+		return handle, nil
+	}
+	sequence := c.globalSequences[path]
+	c.mu.Unlock()
+
+	table, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	table.blockCache = c.blockCache
+	if sequence > 0 {
+		table.setGlobalSequence(sequence)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		table.Close()
+		return nil, ErrClosed
+	}
+
+	// another reader may have opened it in the meantime
+	if entry, ok := c.tables[path]; ok {
+		table.Close()
+		return c.acquire(entry), nil
+	}
+
+	entry := &tableCacheEntry{path: path, table: table}
+	entry.elem = c.lru.PushFront(entry)
+	c.tables[path] = entry
+	handle := c.acquire(entry)
+	c.evictIdle()
+	return handle, nil
+}
+
+func (c *TableCache) acquire(entry *tableCacheEntry) *TableHandle {
+	entry.refs++
+	c.held[entry.path]++
+	c.lru.MoveToFront(entry.elem)
+	return &TableHandle{SSTable: entry.table, cache: c, entry: entry}
+}
+
+func (h *TableHandle) Release() {
+	c := h.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.held[h.entry.path]--; c.held[h.entry.path] == 0 {
+		delete(c.held, h.entry.path)
+	}
+	h.entry.refs--
+	// Close already closed every table
+	if h.entry.refs > 0 || c.closed {
+		return
+	}
+
+	if h.entry.obsolete {
+		c.remove(h.entry)
+		return
+	}
+	c.evictIdle()
+}
+
+// setGlobalSequence makes the table at path read with version sequence once
+// it is opened. It must be called before the table is first acquired.
+func (c *TableCache) setGlobalSequence(path string, sequence uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.globalSequences[path] = sequence
+}
+
+// Evict drops a table from the cache, typically because it was compacted
+// away. If readers still hold it, the file is closed when the last one
+// releases its handle.
+func (c *TableCache) Evict(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blockCache.evictTable(path)
+	delete(c.globalSequences, path)
+
+	entry, ok := c.tables[path]
+	if !ok {
+		return
+	}
+
+	entry.obsolete = true
+	delete(c.tables, path)
+	if entry.refs == 0 {
+		c.remove(entry)
+	}
+}
+
+// InUse reports whether a reader currently holds the table at path.
+func (c *TableCache) InUse(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.held[path] > 0
+}
+
+// Close closes every table, including those still held. Reads through
+// handles acquired earlier return ErrClosed from then on.
+func (c *TableCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	var firstError error
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*tableCacheEntry)
+		if entry.refs > 0 {
+			log.Printf("Warning: closing SSTable %s with %d open readers", entry.path, entry.refs)
+		}
+		if err := entry.table.Close(); err != nil && firstError == nil {
+			firstError = err
+		}
+	}
+
+	c.lru.Init()
+	c.tables = make(map[string]*tableCacheEntry)
+	return firstError
+}
+
+// evictIdle closes least recently used tables nobody is reading until the
+// open file count is back under the limit.
+func (c *TableCache) evictIdle() {
+	elem := c.lru.Back()
+	for c.lru.Len() > c.maxOpenFiles && elem != nil {
+		prev := elem.Prev()
+		entry := elem.Value.(*tableCacheEntry)
+		if entry.refs == 0 {
+			delete(c.tables, entry.path)
+			c.remove(entry)
+		}
+		elem = prev
+	}
+}
+
+func (c *TableCache) remove(entry *tableCacheEntry) {
+	c.lru.Remove(entry.elem)
+	if err := entry.table.Close(); err != nil {
+		log.Printf("Warning: failed to close SSTable %s: %v", entry.path, err)
+	}
+}
@@ -0,0 +1,153 @@
+package sstable
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func newTestTables(t *testing.T, n int) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, TableFileName(uint64(i+1)))
+		writeTestTable(t, paths[i], defaultTableConfig(), put(fmt.Sprintf("k%d", i), i+1))
+	}
+	return paths
+}
+
+func isClosed(table *SSTable) bool {
+	_, err := table.file.Stat()
+	return err != nil
+}
+
+func TestTableCacheSharesOpenTable(t *testing.T) {
+	paths := newTestTables(t, 1)
+	cache := NewTableCache(10, nil)
+	defer cache.Close()
+
+	first, err := cache.Acquire(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cache.Acquire(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.SSTable != second.SSTable {
+		t.Fatal("two handles to one path opened the table twice")
+	}
+
+	first.Release()
+	if !cache.InUse(paths[0]) {
+		t.Fatal("table not in use while a handle is held")
+	}
+	second.Release()
+	if cache.InUse(paths[0]) {
+		t.Fatal("table in use after every handle was released")
+	}
+}
+
+func TestTableCacheEvictsLeastRecentlyUsedIdleTables(t *testing.T) {
+	paths := newTestTables(t, 3)
+	cache := NewTableCache(2, nil)
+	defer cache.Close()
+
+	held, err := cache.Acquire(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idle []*SSTable
+	for _, path := range paths[1:] {
+		handle, err := cache.Acquire(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idle = append(idle, handle.SSTable)
+		handle.Release()
+	}
+
+	if isClosed(held.SSTable) {
+		t.Fatal("a held table was closed to make room")
+	}
+	if !isClosed(idle[0]) || isClosed(idle[1]) {
+		t.Fatal("the least recently used idle table should be the one closed")
+	}
+	if cache.lru.Len() != 2 {
+		t.Fatalf("cache holds %d open tables, want 2", cache.lru.Len())
+	}
+	held.Release()
+}
+
+func TestTableCacheEvictClosesOnLastRelease(t *testing.T) {
+	paths := newTestTables(t, 1)
+	cache := NewTableCache(10, nil)
+	defer cache.Close()
+
+	handle, err := cache.Acquire(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Evict(paths[0])
+	if isClosed(handle.SSTable) {
+		t.Fatal("evicted table closed while a reader holds it")
+	}
+	if !cache.InUse(paths[0]) {
+		t.Fatal("evicted table no longer reported in use while held")
+	}
+
+	reopened, err := cache.Acquire(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.SSTable == handle.SSTable {
+		t.Fatal("evicted table was handed out again")
+	}
+	reopened.Release()
+
+	handle.Release()
+	if !isClosed(handle.SSTable) {
+		t.Fatal("evicted table still open after its last release")
+	}
+	if cache.InUse(paths[0]) {
+		t.Fatal("table in use after every handle was released")
+	}
+}
+
+func TestTableCacheCloseWithHeldTable(t *testing.T) {
+	paths := newTestTables(t, 2)
+	cache := NewTableCache(10, nil)
+
+	held, err := cache.Acquire(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	evicted, err := cache.Acquire(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Evict(paths[1])
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !isClosed(held.SSTable) || !isClosed(evicted.SSTable) {
+		t.Fatal("held tables left open by Close")
+	}
+	if _, err := held.Get(shared.Key("k0")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Get on a closed table returned %v, want ErrClosed", err)
+	}
+
+	held.Release()
+	evicted.Release()
+	if cache.lru.Len() != 0 || cache.InUse(paths[0]) {
+		t.Fatal("releasing after Close changed the closed cache")
+	}
+	if _, err := cache.Acquire(paths[0]); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Acquire after Close returned %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,68 @@
+package sstable
+
+import "fmt"
+
+// CompactionStyle selects how tables are picked for compaction.
+type CompactionStyle byte
+
+const (
+	// LevelCompaction keeps non-overlapping tables in every level below 0,
+	// each level a fixed multiple larger than the one above.
+	LevelCompaction CompactionStyle = iota
+	// UniversalCompaction keeps every table in level 0 as a sorted run and
+	// merges runs of similar size, trading space for less write
+	// amplification.
+	UniversalCompaction
+)
+
+func (s CompactionStyle) String() string {
+	switch s {
+	case LevelCompaction:
+		return "level"
+	case UniversalCompaction:
+		return "universal"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(s))
+	}
+}
+
+// pickUniversalCompaction looks at the sorted runs of level 0 once there are
+// at least Level0CompactionTrigger of them. Runs are always taken from the
+// newest end so the merged run keeps its place in the recency order.
+func (m *SSManager) pickUniversalCompaction() *compaction {
+	runs := m.sstables[0]
+	if len(runs) < max(m.opts.Level0CompactionTrigger, 2) {
+		return nil
+	}
+
+	c := &compaction{level: 0, outputLevel: 0}
+
+	// size amplification: everything newer than the oldest run is space
+	// that a full merge would reclaim
+	oldest := runs[0].size()
+	newer := levelSize(runs) - oldest
+	if oldest > 0 && newer*100 >= oldest*uint64(m.opts.UniversalMaxSizeAmplificationPercent) {
+		c.inputs = runs
+		return c
+	}
+
+	// size ratio: grow the candidate with older runs while each one is at
+	// most UniversalSizeRatio percent larger than the runs picked so far
+	count := 1
+	candidateSize := runs[len(runs)-1].size()
+	for i := len(runs) - 2; i >= 0; i-- {
+		if runs[i].size()*100 > candidateSize*uint64(100+m.opts.UniversalSizeRatio) {
+			break
+		}
+		candidateSize += runs[i].size()
+		count++
+	}
+
+	// without a good candidate, merge just enough runs to get back under
+	// the trigger
+	if count < max(m.opts.UniversalMinMergeWidth, 2) {
+		count = len(runs) - m.opts.Level0CompactionTrigger + 2
+	}
+	c.inputs = runs[len(runs)-min(count, len(runs)):]
+	return c
+}
@@ -0,0 +1,101 @@
+package sstable
+
+import (
+	"testing"
+
+	"github.com/AmrMurad1/Go-Store/shared"
+)
+
+func universalOptions(trigger int) *Options {
+	opts := DefaultOptions()
+	opts.CompactionStyle = UniversalCompaction
+	opts.CompressionPerLevel = []CompressionType{NoCompression}
+	opts.Level0CompactionTrigger = trigger
+	// size amplification is tested on its own
+	opts.UniversalMaxSizeAmplificationPercent = 1 << 20
+	return opts
+}
+
+// addRuns adds one level 0 run per count, oldest first, holding that many
+// entries.
+func addRuns(t *testing.T, m *SSManager, counts ...int) []*tableFile {
+	t.Helper()
+	runs := make([]*tableFile, len(counts))
+	for i, count := range counts {
+		runs[i] = addTable(t, m, 0, sequentialEntries("k", count, i+1)...)
+	}
+	return runs
+}
+
+func assertInputs(t *testing.T, c *compaction, want []*tableFile) {
+	t.Helper()
+	if c == nil {
+		t.Fatal("no compaction picked")
+	}
+	if c.level != 0 || c.outputLevel != 0 {
+		t.Fatalf("picked level %d into %d, want level 0 into itself", c.level, c.outputLevel)
+	}
+	if len(c.inputs) != len(want) {
+		t.Fatalf("picked %d runs, want %d", len(c.inputs), len(want))
+	}
+	for i := range want {
+		if c.inputs[i] != want[i] {
+			t.Fatalf("run %d picked is %s, want %s", i, c.inputs[i].path, want[i].path)
+		}
+	}
+}
+
+func TestUniversalWaitsForTrigger(t *testing.T) {
+	m := newTestManager(t, universalOptions(3))
+	addRuns(t, m, 100, 100)
+	if c := m.pickCompaction(); c != nil {
+		t.Fatalf("picked %d runs below the trigger", len(c.inputs))
+	}
+}
+
+func TestUniversalSizeAmplificationMergesEverything(t *testing.T) {
+	opts := universalOptions(3)
+	opts.UniversalMaxSizeAmplificationPercent = 200
+	m := newTestManager(t, opts)
+	runs := addRuns(t, m, 100, 200, 200)
+
+	assertInputs(t, m.pickCompaction(), runs)
+}
+
+func TestUniversalSizeRatioPicksSimilarRuns(t *testing.T) {
+	m := newTestManager(t, universalOptions(3))
+	runs := addRuns(t, m, 2000, 100, 100, 100)
+
+	assertInputs(t, m.pickCompaction(), runs[1:])
+}
+
+func TestUniversalFallsBackToTrigger(t *testing.T) {
+	m := newTestManager(t, universalOptions(4))
+	runs := addRuns(t, m, 2700, 900, 300, 100, 30)
+
+	// no two neighbouring runs are of similar size, so just enough newest
+	// runs are merged to get back under the trigger
+	assertInputs(t, m.pickCompaction(), runs[2:])
+}
+
+func TestUniversalKeepsRunsInLevelZero(t *testing.T) {
+	m := newTestManager(t, universalOptions(3))
+	addRuns(t, m, 100, 100, 100, 100, 100)
+	if err := m.fixLevels(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.sstables[0]) >= 3 {
+		t.Fatalf("level 0 holds %d runs after compacting, want fewer than 3", len(m.sstables[0]))
+	}
+	for level := 1; level < len(m.sstables); level++ {
+		if len(m.sstables[level]) != 0 {
+			t.Fatalf("level %d holds %d tables, want none", level, len(m.sstables[level]))
+		}
+	}
+
+	entry, err := m.Get(shared.Key("k00042"))
+	if err != nil || entry == nil || entry.Version != 5 {
+		t.Fatalf("Get after compaction returned %+v, %v", entry, err)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/AmrMurad1/Go-Store/shared"
-	"github.com/klauspost/compress/s2"
 )
 
 type BlockWriter struct {
@@ -22,40 +24,128 @@ type BlockWriter struct {
 	currentOffset int64
 	entryCounter  uint64
 	prevKey       shared.Key
+	dictionary    *zstdDictionary
+	collectors    []TablePropertiesCollector
+	// blobs receives the values of at least config.MinBlobSize bytes; it is
+	// created with the first of them.
+	blobs *blobWriter
+
+	rangeTombstones []shared.RangeTombstone
+
+	// partitionEnds holds, for each finished index/filter partition, the
+	// number of data blocks written up to its end.
+	partitionEnds    []int
+	partitionKeys    []string
+	filterPartitions []*Filter
 }
 
 type SSTableConfig struct {
 	DataBlockSize           int
 	FilterFalsePositiveRate float64
 	ExpectedEntryCount      int
+	Compression             CompressionType
+	// DictionarySize enables zstd dictionary training for compaction
+	// outputs when greater than zero.
+	DictionarySize int
+	Collectors     []TablePropertiesCollectorFactory
+	// PartitionedIndex and PartitionedFilter split the index and bloom
+	// filter into partitions of about MetadataBlockSize bytes that are
+	// loaded on demand.
+	PartitionedIndex  bool
+	PartitionedFilter bool
+	MetadataBlockSize int
+	// RateLimiter, when set, throttles every write to the table file at
+	// IOPriority.
+	RateLimiter *RateLimiter
+	IOPriority  IOPriority
+	// MinBlobSize moves values of at least this many bytes to the blob file
+	// of the table. Zero keeps every value in the table.
+	MinBlobSize int
 }
 
+// entry kinds stored in the byte after the value
+const (
+	entryKindValue byte = iota
+	entryKindTombstone
+	entryKindBlobIndex
+)
+
 func NewBlockWriter(filename string, config *SSTableConfig) (*BlockWriter, error) {
 	file, err := os.Create(filename)
 	if err != nil {
 		return nil, err
 	}
 
+	collectors := make([]TablePropertiesCollector, 0, len(config.Collectors))
+	for _, newCollector := range config.Collectors {
+		collectors = append(collectors, newCollector())
+	}
+
+	var output io.Writer = file
+	if config.RateLimiter != nil {
+		output = &rateLimitedWriter{w: file, limiter: config.RateLimiter, priority: config.IOPriority}
+	}
+
 	return &BlockWriter{
 		file:   file,
-		writer: bufio.NewWriter(file),
+		writer: bufio.NewWriter(output),
 		config: config,
 		meta: shared.MetaBlock{
-			Timestamp: time.Now().UnixNano(),
+			Timestamp:   time.Now().UnixNano(),
+			Compression: uint8(config.Compression),
 		},
-		filter: New(config.ExpectedEntryCount, config.FilterFalsePositiveRate),
+		filter:     New(config.ExpectedEntryCount, config.FilterFalsePositiveRate),
+		collectors: collectors,
 	}, nil
 }
 
 func (bw *BlockWriter) Add(entry shared.Entry) error {
+	if bw.config.MinBlobSize > 0 && !entry.Tombstone && !entry.BlobIndex && len(entry.Value) >= bw.config.MinBlobSize {
+		if err := bw.separateValue(&entry); err != nil {
+			return err
+		}
+	}
+	if entry.BlobIndex {
+		ref, err := decodeBlobReference(entry.Value)
+		if err != nil {
+			return err
+		}
+		if bw.meta.BlobReferences == nil {
+			bw.meta.BlobReferences = make(map[uint64]uint64)
+		}
+		bw.meta.BlobReferences[ref.fileNumber] += uint64(ref.size)
+	}
+
 	if bw.entryCounter == 0 {
 		bw.meta.MinKey = entry.Key
 	}
 	bw.meta.MaxKey = entry.Key
 	bw.entryCounter++
-	bw.filter.Add(string(entry.Key))
+	if bw.config.PartitionedFilter {
+		bw.partitionKeys = append(bw.partitionKeys, string(entry.Key))
+	} else {
+		bw.filter.Add(string(entry.Key))
+	}
 
-	lcp := lcp(bw.prevKey, entry.Key)
+	sequence := uint64(entry.Version)
+	if bw.entryCounter == 1 && len(bw.rangeTombstones) == 0 || sequence < bw.meta.MinSequence {
+		bw.meta.MinSequence = sequence
+	}
+	bw.meta.MaxSequence = max(bw.meta.MaxSequence, sequence)
+	bw.meta.RawKeySize += uint64(len(entry.Key))
+	bw.meta.RawValueSize += uint64(len(entry.Value))
+	if entry.Tombstone {
+		bw.meta.TombstoneCount++
+	}
+	for _, collector := range bw.collectors {
+		collector.Add(entry)
+	}
+
+	// blocks are decoded independently, so prefixes never span two blocks
+	lcp := 0
+	if bw.dataBlockBuf.Len() > 0 {
+		lcp = sharedPrefixLen(bw.prevKey, entry.Key)
+	}
 	suffix := entry.Key[lcp:]
 
 	binary.Write(&bw.dataBlockBuf, binary.LittleEndian, uint16(lcp))
@@ -63,7 +153,15 @@ func (bw *BlockWriter) Add(entry shared.Entry) error {
 	bw.dataBlockBuf.Write([]byte(suffix))
 	binary.Write(&bw.dataBlockBuf, binary.LittleEndian, uint32(len(entry.Value)))
 	bw.dataBlockBuf.Write(entry.Value)
-	binary.Write(&bw.dataBlockBuf, binary.LittleEndian, entry.Tombstone)
+	switch {
+	case entry.Tombstone:
+		bw.dataBlockBuf.WriteByte(entryKindTombstone)
+	case entry.BlobIndex:
+		bw.dataBlockBuf.WriteByte(entryKindBlobIndex)
+	default:
+		bw.dataBlockBuf.WriteByte(entryKindValue)
+	}
+	binary.Write(&bw.dataBlockBuf, binary.LittleEndian, uint64(entry.Version))
 
 	bw.prevKey = entry.Key
 
@@ -75,12 +173,39 @@ func (bw *BlockWriter) Add(entry shared.Entry) error {
 	return nil
 }
 
+// separateValue writes the value of entry to the blob file of the table and
+// replaces it with a reference.
+func (bw *BlockWriter) separateValue(entry *shared.Entry) error {
+	if bw.blobs == nil {
+		number, ok := parseFileNumber(filepath.Base(bw.file.Name()))
+		if !ok {
+			return fmt.Errorf("cannot store blobs for unnumbered table %s", bw.file.Name())
+		}
+		blobs, err := newBlobWriter(filepath.Join(filepath.Dir(bw.file.Name()), BlobFileName(number)), number, bw.config)
+		if err != nil {
+			return err
+		}
+		bw.blobs = blobs
+	}
+
+	ref, err := bw.blobs.add(entry.Value)
+	if err != nil {
+		return err
+	}
+	entry.Value = ref.encode()
+	entry.BlobIndex = true
+	return nil
+}
+
 func (bw *BlockWriter) flushDataBlock() error {
 	if bw.dataBlockBuf.Len() == 0 {
 		return nil
 	}
 
-	compressedBlock := s2.Encode(nil, bw.dataBlockBuf.Bytes())
+	compressedBlock, err := compressBlock(bw.config.Compression, bw.dataBlockBuf.Bytes(), bw.dictionary)
+	if err != nil {
+		return err
+	}
 	n, err := bw.writer.Write(compressedBlock)
 	if err != nil {
 		return err
@@ -92,34 +217,197 @@ func (bw *BlockWriter) flushDataBlock() error {
 		Size:    int32(n),
 	})
 
+	bw.meta.RawDataSize += uint64(bw.dataBlockBuf.Len())
+	bw.meta.DataSize += uint64(n)
+	bw.meta.DataBlockCount++
+
 	bw.currentOffset += int64(n)
 	bw.dataBlockBuf.Reset()
+
+	if bw.config.PartitionedIndex || bw.config.PartitionedFilter {
+		start := 0
+		if len(bw.partitionEnds) > 0 {
+			start = bw.partitionEnds[len(bw.partitionEnds)-1]
+		}
+		if indexRecordsSize(bw.indexRecords[start:]) >= bw.config.MetadataBlockSize {
+			bw.cutPartition()
+		}
+	}
 	return nil
 }
 
+// cutPartition closes the current index/filter partition after the last
+// written data block.
+func (bw *BlockWriter) cutPartition() {
+	start := 0
+	if len(bw.partitionEnds) > 0 {
+		start = bw.partitionEnds[len(bw.partitionEnds)-1]
+	}
+	if start == len(bw.indexRecords) {
+		return
+	}
+	bw.partitionEnds = append(bw.partitionEnds, len(bw.indexRecords))
+
+	if bw.config.PartitionedFilter {
+		filter := New(len(bw.partitionKeys), bw.config.FilterFalsePositiveRate)
+		for _, key := range bw.partitionKeys {
+			filter.Add(key)
+		}
+		bw.filterPartitions = append(bw.filterPartitions, filter)
+		bw.partitionKeys = nil
+	}
+}
+
+// writePartitions writes one block per partition and returns the top-level
+// index pointing at them.
+func (bw *BlockWriter) writePartitions(encode func(partition int, records []shared.IndexRecord) []byte) ([]shared.IndexRecord, error) {
+	var topLevel []shared.IndexRecord
+	start := 0
+	for partition, end := range bw.partitionEnds {
+		records := bw.indexRecords[start:end]
+		partitionBytes := encode(partition, records)
+		if _, err := bw.writer.Write(partitionBytes); err != nil {
+			return nil, err
+		}
+
+		topLevel = append(topLevel, shared.IndexRecord{
+			LastKey: records[len(records)-1].LastKey,
+			Offset:  bw.currentOffset,
+			Size:    int32(len(partitionBytes)),
+		})
+		bw.currentOffset += int64(len(partitionBytes))
+		start = end
+	}
+	return topLevel, nil
+}
+
+// setDictionary makes every following data block compress with d. It must
+// be called before the first entry is added.
+func (bw *BlockWriter) setDictionary(d *zstdDictionary) {
+	bw.dictionary = d
+	bw.meta.DictionarySize = uint32(len(d.raw))
+	bw.meta.DictionaryGain = d.gain
+}
+
+// AddRangeTombstone records a range deletion in the table. Range tombstones
+// may be added in any order, before or after the entries.
+func (bw *BlockWriter) AddRangeTombstone(tombstone shared.RangeTombstone) {
+	sequence := uint64(tombstone.Version)
+	if bw.entryCounter == 0 && len(bw.rangeTombstones) == 0 || sequence < bw.meta.MinSequence {
+		bw.meta.MinSequence = sequence
+	}
+	bw.meta.MaxSequence = max(bw.meta.MaxSequence, sequence)
+	bw.meta.RangeDelCount++
+	bw.rangeTombstones = append(bw.rangeTombstones, tombstone)
+}
+
+// estimatedSize returns the number of data bytes written so far, including
+// the block still being built.
+func (bw *BlockWriter) estimatedSize() uint64 {
+	return uint64(bw.currentOffset) + uint64(bw.dataBlockBuf.Len())
+}
+
+// Abandon closes an unfinished table and removes it together with its blob
+// file. It may also be called after Finish failed.
+func (bw *BlockWriter) Abandon() {
+	bw.file.Close()
+	os.Remove(bw.file.Name())
+	if bw.blobs != nil {
+		bw.blobs.file.Close()
+		os.Remove(bw.blobs.file.Name())
+	}
+}
+
 func (bw *BlockWriter) Finish() error {
 	if err := bw.flushDataBlock(); err != nil {
 		return err
 	}
+	// the blob file is complete before any table refers to it
+	if bw.blobs != nil {
+		if err := bw.blobs.finish(); err != nil {
+			return err
+		}
+	}
+	if bw.config.PartitionedIndex || bw.config.PartitionedFilter {
+		bw.cutPartition()
+	}
 
 	bw.meta.EntryCount = bw.entryCounter
 
+	// the key range of the table includes what its range tombstones delete
+	for i, tombstone := range bw.rangeTombstones {
+		if bw.entryCounter == 0 && i == 0 {
+			bw.meta.MinKey, bw.meta.MaxKey = tombstone.Start, tombstone.End
+			continue
+		}
+		if tombstone.Start.Compare(bw.meta.MinKey) < 0 {
+			bw.meta.MinKey = tombstone.Start
+		}
+		if tombstone.End.Compare(bw.meta.MaxKey) > 0 {
+			bw.meta.MaxKey = tombstone.End
+		}
+	}
+
+	for _, collector := range bw.collectors {
+		for name, value := range collector.Finish() {
+			if bw.meta.UserProperties == nil {
+				bw.meta.UserProperties = make(map[string]string)
+			}
+			bw.meta.UserProperties[collector.Name()+"."+name] = value
+		}
+	}
+
+	dictionaryOffset := bw.currentOffset
+	var dictionaryBytes []byte
+	if bw.dictionary != nil {
+		dictionaryBytes = bw.dictionary.raw
+	}
+	if _, err := bw.writer.Write(dictionaryBytes); err != nil {
+		return err
+	}
+	bw.currentOffset += int64(len(dictionaryBytes))
+
+	rangeDelOffset := bw.currentOffset
+	rangeDelBytes := encodeRangeTombstones(bw.rangeTombstones)
+	if _, err := bw.writer.Write(rangeDelBytes); err != nil {
+		return err
+	}
+	bw.currentOffset += int64(len(rangeDelBytes))
+
+	var filterBytes []byte
+	if bw.config.PartitionedFilter && len(bw.filterPartitions) > 0 {
+		filterIndex, err := bw.writePartitions(func(partition int, _ []shared.IndexRecord) []byte {
+			return bw.filterPartitions[partition].Encode()
+		})
+		if err != nil {
+			return err
+		}
+		bw.meta.FilterPartitions = uint32(len(filterIndex))
+		filterBytes = encodeIndexRecords(filterIndex)
+	} else {
+		filterBytes = bw.filter.Encode()
+	}
+
 	filterOffset := bw.currentOffset
-	filterBytes := bw.filter.Encode()
 	if _, err := bw.writer.Write(filterBytes); err != nil {
 		return err
 	}
 	bw.currentOffset += int64(len(filterBytes))
 
+	indexRecords := bw.indexRecords
+	if bw.config.PartitionedIndex {
+		topLevel, err := bw.writePartitions(func(_ int, records []shared.IndexRecord) []byte {
+			return encodeIndexRecords(records)
+		})
+		if err != nil {
+			return err
+		}
+		bw.meta.IndexPartitions = uint32(len(topLevel))
+		indexRecords = topLevel
+	}
+
 	metaBlockOffset := bw.currentOffset
-	metaBuf := new(bytes.Buffer)
-	binary.Write(metaBuf, binary.LittleEndian, bw.meta.EntryCount)
-	binary.Write(metaBuf, binary.LittleEndian, uint32(len(bw.meta.MinKey)))
-	metaBuf.Write([]byte(bw.meta.MinKey))
-	binary.Write(metaBuf, binary.LittleEndian, uint32(len(bw.meta.MaxKey)))
-	metaBuf.Write([]byte(bw.meta.MaxKey))
-	binary.Write(metaBuf, binary.LittleEndian, bw.meta.Timestamp)
-	metaBlockBytes := metaBuf.Bytes()
+	metaBlockBytes := encodeMetaBlock(&bw.meta)
 	if _, err := bw.writer.Write(metaBlockBytes); err != nil {
 		return err
 	}
@@ -127,14 +415,7 @@ func (bw *BlockWriter) Finish() error {
 
 	// write index block
 	indexBlockOffset := bw.currentOffset
-	indexBuf := new(bytes.Buffer)
-	for _, record := range bw.indexRecords {
-		binary.Write(indexBuf, binary.LittleEndian, uint32(len(record.LastKey)))
-		indexBuf.Write([]byte(record.LastKey))
-		binary.Write(indexBuf, binary.LittleEndian, record.Offset)
-		binary.Write(indexBuf, binary.LittleEndian, record.Size)
-	}
-	indexBlockBytes := indexBuf.Bytes()
+	indexBlockBytes := encodeIndexRecords(indexRecords)
 	if _, err := bw.writer.Write(indexBlockBytes); err != nil {
 		return err
 	}
@@ -148,6 +429,11 @@ func (bw *BlockWriter) Finish() error {
 		MetaBlockSize:    uint32(len(metaBlockBytes)),
 		IndexBlockOffset: indexBlockOffset,
 		IndexBlockSize:   uint32(len(indexBlockBytes)),
+		DictionaryOffset: dictionaryOffset,
+		DictionarySize:   uint32(len(dictionaryBytes)),
+		RangeDelOffset:   rangeDelOffset,
+		RangeDelSize:     uint32(len(rangeDelBytes)),
+		FormatVersion:    shared.FormatVersion,
 		Magic:            shared.MagicNumber,
 	}
 	footerBuf := new(bytes.Buffer)
@@ -157,12 +443,17 @@ func (bw *BlockWriter) Finish() error {
 	}
 
 	if err := bw.writer.Flush(); err != nil {
+		bw.file.Close()
 		return err
 	}
+	if err := bw.file.Sync(); err != nil {
+		bw.file.Close()
+		return fmt.Errorf("failed to sync sstable: %w", err)
+	}
 	return bw.file.Close()
 }
 
-func lcp(a, b shared.Key) int {
+func sharedPrefixLen(a, b shared.Key) int {
 	i := 0
 	for i < len(a) && i < len(b) && a[i] == b[i] {
 		i++